@@ -4,7 +4,7 @@ go 1.19
 
 require (
 	github.com/go-redis/redis/v8 v8.11.5
-	gopkg.in/telebot.v3 v3.0.0
+	gopkg.in/telebot.v3 v3.3.8
 )
 
 require (
@@ -0,0 +1,74 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"net/url"
+	"strings"
+)
+
+const TelegramMaximumButtonTitle = 64
+
+// PostButton is an inline url button attached to the post
+type PostButton struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// parsePostButtons parses buttons, one per line, formatted as 'Title | https://...'
+func parsePostButtons(text string) ([]PostButton, error) {
+	buttons := make([]PostButton, 0)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		separator := strings.LastIndex(line, "|")
+		if separator < 0 {
+			return nil, errors.New(fmt.Sprintf("'%s' should be formatted as 'Title | https://...'", line))
+		}
+		button := PostButton{
+			Title: strings.TrimSpace(line[:separator]),
+			URL:   strings.TrimSpace(line[separator+1:]),
+		}
+		err := button.Validate()
+		if err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, button)
+	}
+	if len(buttons) == 0 {
+		return nil, errors.New("no buttons are provided")
+	}
+	return buttons, nil
+}
+
+func (button PostButton) Validate() error {
+	if button.Title == "" || len([]rune(button.Title)) > TelegramMaximumButtonTitle {
+		return errors.New(fmt.Sprintf("title of the button must be from 1 to %d characters long", TelegramMaximumButtonTitle))
+	}
+	link, err := url.Parse(button.URL)
+	if err != nil || strings.ContainsAny(button.URL, " \t") || link.Host == "" && link.Scheme != "tg" {
+		return errors.New(fmt.Sprintf("'%s' is not a valid url", button.URL))
+	}
+	switch link.Scheme {
+	case "http", "https", "tg":
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("'%s' is not a valid url, only http, https and tg links are allowed", button.URL))
+	}
+}
+
+// ReplyMarkup renders buttons of the post one per row, nil if the post has none
+func (post *PostInfo) ReplyMarkup() *tele.ReplyMarkup {
+	if len(post.Buttons) == 0 {
+		return nil
+	}
+	markup := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, len(post.Buttons))
+	for i, button := range post.Buttons {
+		rows[i] = markup.Row(markup.URL(button.Title, button.URL))
+	}
+	markup.Inline(rows...)
+	return markup
+}
@@ -0,0 +1,74 @@
+package joi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePostButtons(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		expected string
+		fails    bool
+	}{
+		{text: "Source | https://example.com/art?id=1", expected: "[{Source https://example.com/art?id=1}]"},
+		{text: "Buy print|https://shop.example.com\n\nDiscuss | tg://resolve?domain=chat",
+			expected: "[{Buy print https://shop.example.com} {Discuss tg://resolve?domain=chat}]"},
+		{text: "A | B | https://example.com", expected: "[{A | B https://example.com}]"},
+		{text: "Source https://example.com", fails: true},
+		{text: " | https://example.com", fails: true},
+		{text: "Source | example.com", fails: true},
+		{text: "Source | ftp://example.com", fails: true},
+		{text: "Source | https://exa mple.com", fails: true},
+		{text: "", fails: true},
+	} {
+		buttons, err := parsePostButtons(test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("'%s' is expected to fail, got %v", test.text, buttons)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s' failed: %s", test.text, err.Error())
+		} else if fmt.Sprint(buttons) != test.expected {
+			t.Errorf("'%s' is parsed to %v, expected %s", test.text, buttons, test.expected)
+		}
+	}
+}
+
+func TestIsDotCommand(t *testing.T) {
+	for text, expected := range map[string]bool{
+		".btn":                               true,
+		".BTN -":                             true,
+		".btn Source | https://example.com":  true,
+		".btn\nSource | https://example.com": true,
+		".btnfoo":                            false,
+		".buttons":                           false,
+		"btn":                                false,
+	} {
+		if isDotCommand(text, ".btn") != expected {
+			t.Errorf("'%s' is expected to be .btn=%t", text, expected)
+		}
+	}
+}
+
+func TestIsDotCommand_rearrangement(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		command  string
+		expected bool
+	}{
+		{".merge", ".merge", true},
+		{".MERGE 1692000000_1", ".merge", true},
+		{".split\n2", ".split", true},
+		{".order", ".order", true},
+		{".drop 2", ".drop", true},
+		{".dropped", ".drop", false},
+		{".orders 1 2", ".order", false},
+	} {
+		if isDotCommand(test.text, test.command) != test.expected {
+			t.Errorf("'%s' is expected to be %s=%t", test.text, test.command, test.expected)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	tele "gopkg.in/telebot.v3"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 const (
@@ -12,24 +14,52 @@ const (
 	DefaultRedisAddress            = "localhost:6379"
 	DefaultParseMode               = tele.ModeMarkdownV2
 	DefaultDefaultPostText         = ""
+	DefaultButtonsMessageText      = "🔗"
+	DefaultCaptionCommentMarker    = "---"
+	DefaultAttributionTemplate     = "via {{if .Username}}@{{.Username}}{{else}}{{.Title}}{{end}}"
+	DefaultAttributionTarget       = AttributionToText
 )
 
 type Config struct {
 	Token            string   `json:"token"`
 	AdminList        []int64  `json:"admin-list"`
-	DefaultPostTimes []string `json:"default-post-times"`
+	DefaultPostTimes []string `json:"default-post-times"` // i.e. 06:06, or 06:06#morning to prefer posts with the tag
 	ChannelId        int64    `json:"channel-id"`
 	CommentsId       int64    `json:"comments-id"`
+	StorageChatId    int64    `json:"storage-chat-id,omitempty"`
 
 	TemporaryFilesDirectory string `json:"temporary-files-directory,omitempty"`
 	RedisPrefix             string `json:"redis-prefix,omitempty"`
 	RedisAddress            string `json:"redis-address,omitempty"`
 	RedisDatabaseNumber     int    `json:"redis-database-number,omitempty"`
 
-	DefaultPostText       string `json:"default-post-text,omitempty"`
-	ParseMode             string `json:"parse-mode,omitempty"`
+	HotFolder               string `json:"hot-folder,omitempty"`         // files dropped there become posts
+	HotFolderArchive        string `json:"hot-folder-archive,omitempty"` // <hot-folder>/archive by default
+	HotFolderPollingSeconds int    `json:"hot-folder-polling-seconds,omitempty"`
+
+	ConversionParallelism    int `json:"conversion-parallelism,omitempty"`
+	ConversionTimeoutSeconds int `json:"conversion-timeout-seconds,omitempty"`
+
+	DefaultPostText       string `json:"default-post-text,omitempty"`      // in the parse mode
+	CaptionCommentMarker  string `json:"caption-comment-marker,omitempty"` // caption lines after it go to the comment
+	ParseMode             string `json:"parse-mode,omitempty"`             // MarkdownV2, Markdown, HTML or Entities to send them natively
 	DisableWebPagePreview bool   `json:"disable-web-page-preview,omitempty"`
+	OverflowTextToComment bool   `json:"overflow-text-to-comment,omitempty"` // the end of the text longer than Telegram allows goes to the comment
 	DisableNotification   bool   `json:"disable-notification,omitempty"`
+
+	// custom variables for templates in texts and comments of posts, i.e. {{.Signature}}, see postTemplateData for the built-in ones
+	TemplateVariables map[string]string `json:"template-variables,omitempty"`
+
+	// text/template with fields of PostOrigin, added to the text or the comment of posts forwarded from other channels
+	AttributionTemplate string `json:"attribution-template,omitempty"`
+	AttributionTarget   string `json:"attribution-target,omitempty"` // "text" or "comment"
+
+	// maximum hamming distance between perceptual hashes of duplicate photos, 0 for exact matches only,
+	// negative disables the check, DefaultDuplicateHashDistance if it isn't set
+	DuplicateHashDistance *int `json:"duplicate-hash-distance,omitempty"`
+
+	DefaultButtons     []PostButton `json:"default-buttons,omitempty"`      // attached to every new post
+	ButtonsMessageText string       `json:"buttons-message-text,omitempty"` // text of the message with buttons sent after an album
 }
 
 func (cfg Config) FillDefaults() Config {
@@ -45,9 +75,37 @@ func (cfg Config) FillDefaults() Config {
 	if cfg.ParseMode == "" {
 		cfg.ParseMode = DefaultParseMode
 	}
+	if cfg.HotFolder != "" && cfg.HotFolderArchive == "" {
+		cfg.HotFolderArchive = filepath.Join(cfg.HotFolder, "archive")
+	}
+	if cfg.HotFolderPollingSeconds <= 0 {
+		cfg.HotFolderPollingSeconds = int(DefaultHotFolderPolling / time.Second)
+	}
+	if cfg.ConversionParallelism <= 0 {
+		cfg.ConversionParallelism = DefaultConversionParallelism
+	}
+	if cfg.ConversionTimeoutSeconds <= 0 {
+		cfg.ConversionTimeoutSeconds = int(DefaultConversionTimeout / time.Second)
+	}
 	if cfg.DefaultPostText == "" {
 		cfg.DefaultPostText = DefaultDefaultPostText
 	}
+	if cfg.CaptionCommentMarker == "" {
+		cfg.CaptionCommentMarker = DefaultCaptionCommentMarker
+	}
+	if cfg.AttributionTemplate == "" {
+		cfg.AttributionTemplate = DefaultAttributionTemplate
+	}
+	if cfg.AttributionTarget == "" {
+		cfg.AttributionTarget = DefaultAttributionTarget
+	}
+	if cfg.DuplicateHashDistance == nil {
+		distance := DefaultDuplicateHashDistance
+		cfg.DuplicateHashDistance = &distance
+	}
+	if cfg.ButtonsMessageText == "" {
+		cfg.ButtonsMessageText = DefaultButtonsMessageText
+	}
 	return cfg
 }
 
@@ -0,0 +1,30 @@
+package joi
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConfig_DuplicateHashDistance(t *testing.T) {
+	for config, expected := range map[string]int{
+		`{}`:                              DefaultDuplicateHashDistance,
+		`{"duplicate-hash-distance": 0}`:  0,
+		`{"duplicate-hash-distance": 3}`:  3,
+		`{"duplicate-hash-distance": -1}`: -1,
+	} {
+		filename := path.Join(t.TempDir(), "config.json")
+		err := os.WriteFile(filename, []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg = cfg.FillDefaults()
+		if cfg.DuplicateHashDistance == nil || *cfg.DuplicateHashDistance != expected {
+			t.Errorf("distance of %s is %v, expected %d", config, cfg.DuplicateHashDistance, expected)
+		}
+	}
+}
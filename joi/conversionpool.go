@@ -0,0 +1,102 @@
+package joi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConversionParallelism = 4
+	DefaultConversionTimeout     = 5 * time.Minute
+)
+
+// ConversionPool runs downloads and conversions concurrently, but no more than Parallelism of them at once.
+// Each job gets its own context, which is cancelled after Timeout or when the pool is stopped.
+type ConversionPool struct {
+	Parallelism int
+	Timeout     time.Duration
+
+	slots   chan struct{}
+	running sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewConversionPool(parallelism int, timeout time.Duration) *ConversionPool {
+	if parallelism <= 0 {
+		parallelism = DefaultConversionParallelism
+	}
+	if timeout <= 0 {
+		timeout = DefaultConversionTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConversionPool{
+		Parallelism: parallelism,
+		Timeout:     timeout,
+		slots:       make(chan struct{}, parallelism),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Run runs the jobs concurrently and waits for all of them, errors are returned in the same order as the jobs
+func (pool *ConversionPool) Run(jobs ...func(ctx context.Context) error) []error {
+	errs := make([]error, len(jobs))
+
+	wg := sync.WaitGroup{}
+	for i, job := range jobs {
+		wg.Add(1)
+		pool.running.Add(1)
+		go func(i int, job func(ctx context.Context) error) {
+			defer pool.running.Done()
+			defer wg.Done()
+
+			select {
+			case pool.slots <- struct{}{}:
+			case <-pool.ctx.Done():
+				errs[i] = pool.ctx.Err()
+				return
+			}
+			defer func() { <-pool.slots }()
+			if pool.ctx.Err() != nil {
+				errs[i] = pool.ctx.Err()
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(pool.ctx, pool.Timeout)
+			defer cancel()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = errors.New(fmt.Sprintf("%v", r))
+				}
+			}()
+
+			errs[i] = job(ctx)
+			if errs[i] != nil && ctx.Err() == context.DeadlineExceeded {
+				errs[i] = errors.New(fmt.Sprintf("timed out after %s, %s", pool.Timeout, errs[i].Error()))
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Stop cancels all the running and queued jobs and waits for them to finish
+func (pool *ConversionPool) Stop() {
+	pool.cancel()
+	pool.running.Wait()
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
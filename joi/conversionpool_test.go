@@ -0,0 +1,114 @@
+package joi
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConversionPool_Run(t *testing.T) {
+	pool := NewConversionPool(2, time.Second)
+	defer pool.Stop()
+
+	var running, maxRunning int32
+	started := make(chan int, 5)
+	release := make([]chan struct{}, 5)
+	results := make([]int, len(release))
+	jobs := make([]func(context.Context) error, len(release))
+	for i := range jobs {
+		i := i
+		release[i] = make(chan struct{})
+		jobs[i] = func(ctx context.Context) error {
+			now := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+					break
+				}
+			}
+
+			started <- i
+			<-release[i]
+			results[i] = i
+			if i == 3 {
+				return errors.New("job 3 failed")
+			}
+			return nil
+		}
+	}
+
+	done := make(chan []error)
+	go func() {
+		done <- pool.Run(jobs...)
+	}()
+	// once both slots are taken, the latest started job is let to finish, so the jobs finish out of order
+	waiting := make([]int, 0, len(jobs))
+	for received := 0; received < len(jobs) || len(waiting) > 0; {
+		if len(waiting) < 2 && received < len(jobs) {
+			waiting = append(waiting, <-started)
+			received++
+			continue
+		}
+		close(release[waiting[len(waiting)-1]])
+		waiting = waiting[:len(waiting)-1]
+	}
+	errs := <-done
+
+	for i, err := range errs {
+		if (err != nil) != (i == 3) {
+			t.Fatalf("unexpected error of job %d: %v", i, err)
+		}
+	}
+	for i, result := range results {
+		if result != i {
+			t.Fatalf("results are out of order: %v", results)
+		}
+	}
+	if maxRunning > 2 {
+		t.Fatalf("%d jobs were running at once, parallelism is 2", maxRunning)
+	}
+}
+
+func TestConversionPool_Timeout(t *testing.T) {
+	pool := NewConversionPool(1, 50*time.Millisecond)
+	defer pool.Stop()
+
+	errs := pool.Run(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if errs[0] == nil {
+		t.Fatal("job hasn't timed out")
+	}
+}
+
+func TestConversionPool_Stop(t *testing.T) {
+	pool := NewConversionPool(1, time.Minute)
+
+	started := make(chan struct{}, 2)
+	job := func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	done := make(chan []error)
+	go func() {
+		done <- pool.Run(job, job)
+	}()
+	<-started // the second job waits for the slot
+	pool.Stop()
+
+	select {
+	case errs := <-done:
+		for i, err := range errs {
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("job %d wasn't cancelled: %v", i, err)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("jobs are still running after %s", time.Second)
+	}
+}
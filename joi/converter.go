@@ -1,6 +1,7 @@
 package joi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -10,22 +11,33 @@ import (
 )
 
 const (
-	DefaultConvertPath  = "convert"
-	DefaultIdentifyPath = "identify"
-	DefaultFfmpegPath   = "ffmpeg"
-	DefaultMaximumSizes = "3840x3840"
-	DefaultJpgQuality   = "95"
-	DefaultPreset       = "fast"
+	DefaultConvertPath     = "convert"
+	DefaultIdentifyPath    = "identify"
+	DefaultFfmpegPath      = "ffmpeg"
+	DefaultHeifConvertPath = "heif-convert"
+	DefaultDwebpPath       = "dwebp"
+	DefaultMaximumSizes    = "3840x3840"
+	DefaultJpgQuality      = "95"
+	DefaultMinJpgQuality   = "80"
+	DefaultPreset          = "fast"
+)
+
+const (
+	TelegramMaximumPhotoSizeAllowed = 5 * megabyte
+	MinimalTelegramImageSide        = 320
 )
 
 type Converter struct {
-	ConvertPath  string
-	FfmpegPath   string
-	IdentifyPath string
+	ConvertPath     string
+	FfmpegPath      string
+	IdentifyPath    string
+	HeifConvertPath string
+	DwebpPath       string
 
-	MaximumSizes string
-	JpgQuality   string
-	Preset       string
+	MaximumSizes  string
+	JpgQuality    string
+	MinJpgQuality string
+	Preset        string
 }
 
 func NewConverter(args ...Converter) *Converter {
@@ -43,6 +55,12 @@ func NewConverter(args ...Converter) *Converter {
 	if converter.FfmpegPath == "" {
 		converter.FfmpegPath = DefaultFfmpegPath
 	}
+	if converter.HeifConvertPath == "" {
+		converter.HeifConvertPath = DefaultHeifConvertPath
+	}
+	if converter.DwebpPath == "" {
+		converter.DwebpPath = DefaultDwebpPath
+	}
 	if converter.Preset == "" {
 		converter.Preset = DefaultPreset
 	}
@@ -52,22 +70,28 @@ func NewConverter(args ...Converter) *Converter {
 	if converter.JpgQuality == "" {
 		converter.JpgQuality = DefaultJpgQuality
 	}
+	if converter.MinJpgQuality == "" {
+		converter.MinJpgQuality = DefaultMinJpgQuality
+	}
 
 	return &converter
 }
 
-func (converter *Converter) IdentifyImage(filename string) (*ImageInfo, error) {
+func (converter *Converter) IdentifyImage(ctx context.Context, filename string) (*ImageInfo, error) {
 	stat, err := os.Stat(filename)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("while identifying %s an error occured %s", filename, err.Error()))
 	}
 	info := ImageInfo{Size: stat.Size()}
 
-	output, err := exec.Command(converter.IdentifyPath, filename).Output()
+	output, err := exec.CommandContext(ctx, converter.IdentifyPath, filename).Output()
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("while identifying %s an error occured %s\n%s", filename, err.Error(), string(output)))
 	}
-	args := strings.Split(string(output), " ")
+	// identify prints a line per frame
+	frames := strings.Split(strings.TrimSpace(string(output)), "\n")
+	info.Animated = len(frames) > 1
+	args := strings.Split(frames[0], " ")
 	if len(args) < 3 {
 		return nil, errors.New(fmt.Sprintf("while identifying %s, weird info has gotten %s", filename, string(output)))
 	}
@@ -81,40 +105,163 @@ func (converter *Converter) IdentifyImage(filename string) (*ImageInfo, error) {
 	return &info, nil
 }
 
-func (converter *Converter) Image(filename string) (string, error) {
+func (converter *Converter) Image(ctx context.Context, filename string) (string, error) {
 	newImg := filename + ".jpg"
-	output, err := exec.Command(converter.ConvertPath, "-strip", "-resize", converter.MaximumSizes, "-quality", converter.JpgQuality, filename, newImg).Output()
+	output, err := exec.CommandContext(ctx, converter.ConvertPath, "-strip", "-resize", converter.MaximumSizes, "-quality", converter.JpgQuality, filename, newImg).Output()
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
 	}
 	return newImg, nil
 }
 
-func (converter *Converter) ImageTelegram(filename string) (string, error) {
-	quality, _ := strconv.ParseInt(converter.JpgQuality, 10, 64)
-	for quality >= 80 {
-		info, err := converter.IdentifyImage(filename)
-		if err != nil {
-			return "", err
+// TelegramImage is an image prepared to be sent as a Telegram photo
+type TelegramImage struct {
+	Filename string
+	Quality  int // 0 if the original file is used as is
+	Width    int
+	Height   int
+	Size     int64
+}
+
+// ImageTelegram searches for the highest jpeg quality (and the highest resolution, if quality alone isn't enough),
+// which fits into Telegram photo limits. The original file is left untouched, the result is written next to it.
+func (converter *Converter) ImageTelegram(ctx context.Context, filename string) (image *TelegramImage, err error) {
+	format, err := DetectImageFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !IsImageFormatSupported(format) {
+		return nil, errors.New(fmt.Sprintf("%s is not a supported image", filename))
+	}
+	if !needsDecoding(format) {
+		return converter.imageTelegram(ctx, filename)
+	}
+
+	decoded, err := converter.Decode(ctx, filename, format)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if image == nil || image.Filename != decoded {
+			_ = os.Remove(decoded)
 		}
-		if info.IsTooBigForTelegram() {
-			newImg := filename + ".jpg"
-			output, err := exec.Command(converter.ConvertPath, "-strip", "-resize", converter.MaximumSizes,
-				"-quality", strconv.Itoa(int(quality)),
-				filename, newImg).Output()
+	}()
+	return converter.imageTelegram(ctx, decoded)
+}
+
+// Decode converts formats, which ImageMagick might not be able to read without extra delegates, into png.
+// Dedicated decoders are used if they are installed, ImageMagick otherwise.
+func (converter *Converter) Decode(ctx context.Context, filename string, format string) (string, error) {
+	newImg := filename + ".png"
+
+	var cmd *exec.Cmd
+	switch format {
+	case ImageFormatHeic, ImageFormatAvif:
+		cmd = exec.CommandContext(ctx, converter.HeifConvertPath, filename, newImg)
+	case ImageFormatWebp:
+		cmd = exec.CommandContext(ctx, converter.DwebpPath, filename, "-o", newImg)
+	default:
+		cmd = exec.CommandContext(ctx, converter.ConvertPath, filename+"[0]", newImg)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		output, err = exec.CommandContext(ctx, converter.ConvertPath, filename+"[0]", newImg).CombinedOutput()
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("while decoding %s (%s) an error occured %s\n%s", filename, format, err.Error(), string(output)))
+	}
+	return newImg, nil
+}
+
+func (converter *Converter) imageTelegram(ctx context.Context, filename string) (*TelegramImage, error) {
+	info, err := converter.IdentifyImage(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsTooBigForTelegram() {
+		return &TelegramImage{Filename: filename, Width: info.Sizes.Width, Height: info.Sizes.Height, Size: info.Size}, nil
+	}
+
+	maxQuality, err := strconv.Atoi(converter.JpgQuality)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("jpeg quality %s is invalid", converter.JpgQuality))
+	}
+	minQuality, err := strconv.Atoi(converter.MinJpgQuality)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("minimal jpeg quality %s is invalid", converter.MinJpgQuality))
+	}
+	var width, height int
+	_, err = fmt.Sscanf(converter.MaximumSizes, "%dx%d", &width, &height)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("maximum sizes %s are invalid", converter.MaximumSizes))
+	}
+	if info.Sizes.Width > 0 && info.Sizes.Width < width {
+		width = info.Sizes.Width
+	}
+	if info.Sizes.Height > 0 && info.Sizes.Height < height {
+		height = info.Sizes.Height
+	}
+
+	newImg := filename + ".tg.jpg"
+	candidate := filename + ".tg.tmp.jpg"
+	defer func() { _ = os.Remove(candidate) }()
+
+	// the original size is always tried, even if a side of it is under the minimum, i.e. for panoramas
+	for {
+		bestQuality := 0
+		left, right := minQuality, maxQuality
+		for left <= right {
+			quality := (left + right) / 2
+			size, err := converter.encodeJpg(ctx, filename, candidate, width, height, quality)
 			if err != nil {
-				return "", errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
+				return nil, err
 			}
-			err = os.Rename(newImg, filename)
+			if size <= TelegramMaximumPhotoSizeAllowed {
+				bestQuality = quality
+				err = os.Rename(candidate, newImg)
+				if err != nil {
+					return nil, err
+				}
+				left = quality + 1
+			} else {
+				right = quality - 1
+			}
+		}
+
+		if bestQuality > 0 {
+			result, err := converter.IdentifyImage(ctx, newImg)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			quality -= 5
-		} else {
-			return filename, err
+			return &TelegramImage{
+				Filename: newImg,
+				Quality:  bestQuality,
+				Width:    result.Sizes.Width,
+				Height:   result.Sizes.Height,
+				Size:     result.Size,
+			}, nil
+		}
+
+		if width*3/4 < MinimalTelegramImageSide || height*3/4 < MinimalTelegramImageSide {
+			return nil, errors.New(fmt.Sprintf("%s can't be fit into %dMB even at %dx%d and quality %d",
+				filename, TelegramMaximumPhotoSizeAllowed/megabyte, width, height, minQuality))
 		}
+		width, height = width*3/4, height*3/4
+	}
+}
+
+func (converter *Converter) encodeJpg(ctx context.Context, filename string, newImg string, width int, height int, quality int) (int64, error) {
+	output, err := exec.CommandContext(ctx, converter.ConvertPath, "-strip", "-resize", fmt.Sprintf("%dx%d>", width, height),
+		"-quality", strconv.Itoa(quality), filename+"[0]", newImg).CombinedOutput()
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
+	}
+	stat, err := os.Stat(newImg)
+	if err != nil {
+		return 0, err
 	}
-	return "", errors.New("what the hell is broken with " + filename)
+	return stat.Size(), nil
 }
 
 type ImageInfo struct {
@@ -122,11 +269,23 @@ type ImageInfo struct {
 	Sizes struct {
 		Height, Width int
 	}
-	Type string
+	Type     string
+	Animated bool
+}
+
+// IsAnimatedImage tells whether the image has more than one frame, i.e. it's an animated webp or png.
+// Images, which can't be identified, are considered still ones, ImageTelegram reports the problem with them then.
+func (converter *Converter) IsAnimatedImage(ctx context.Context, filename string) bool {
+	format, err := DetectImageFormat(filename)
+	if err != nil || !canBeAnimated(format) {
+		return false
+	}
+	info, err := converter.IdentifyImage(ctx, filename)
+	return err == nil && info.Animated
 }
 
 func (info *ImageInfo) IsTooBigForTelegram() bool {
-	if info.Size > 5_000_000 || info.Sizes.Width > 3840 || info.Sizes.Height > 3840 {
+	if info.Animated || info.Size > TelegramMaximumPhotoSizeAllowed || info.Sizes.Width > 3840 || info.Sizes.Height > 3840 {
 		return true
 	}
 	for _, ext := range []string{"png", "jpg", "jpeg"} {
@@ -137,12 +296,35 @@ func (info *ImageInfo) IsTooBigForTelegram() bool {
 	return true
 }
 
-func (converter *Converter) Video(filename string) (string, error) {
+func (converter *Converter) Video(ctx context.Context, filename string) (string, error) {
 	newVideo := filename + "_h264.mp4"
-	output, err := exec.Command("ffmpeg", "-i", filename, "-vcodec", "libx264", "-acodec", "aac", "-y",
+	output, err := exec.CommandContext(ctx, converter.FfmpegPath, "-i", filename, "-vcodec", "libx264", "-acodec", "aac", "-y",
 		"-preset", "fast", "-map_metadata", "-1", "-metadata", "meh=t.me/by_meh", newVideo).Output()
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
 	}
 	return newVideo, nil
 }
+
+// AnimationToVideo converts gifs and animations to silent mp4, which could be sent either as an animation or as a video
+func (converter *Converter) AnimationToVideo(ctx context.Context, filename string) (string, error) {
+	input := filename
+	if format, err := DetectImageFormat(filename); err == nil && format == ImageFormatWebp {
+		// ffmpeg doesn't read animated webp, ImageMagick turns it into a gif first
+		input = filename + ".gif"
+		output, err := exec.CommandContext(ctx, converter.ConvertPath, filename, input).CombinedOutput()
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
+		}
+		defer func() { _ = os.Remove(input) }()
+	}
+
+	newVideo := filename + "_silent.mp4"
+	output, err := exec.CommandContext(ctx, converter.FfmpegPath, "-i", input, "-an", "-vcodec", "libx264", "-y",
+		"-pix_fmt", "yuv420p", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-movflags", "+faststart",
+		"-preset", converter.Preset, "-map_metadata", "-1", newVideo).CombinedOutput()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("while converting %s an error occured %s\n%s", filename, err.Error(), string(output)))
+	}
+	return newVideo, nil
+}
@@ -0,0 +1,172 @@
+package joi
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+// testdataCopy copies the sample into a temporary directory, since the converter writes its results next to the file
+func testdataCopy(t *testing.T, filename string) string {
+	content, err := os.ReadFile(path.Join("testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := path.Join(t.TempDir(), filename)
+	err = os.WriteFile(copied, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
+
+func skipWithoutTools(t *testing.T, tools ...string) {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+}
+
+func TestConverter_Decode(t *testing.T) {
+	converter := NewConverter()
+	for filename, decoder := range map[string]string{
+		"sample.heic": converter.HeifConvertPath,
+		"sample.avif": converter.HeifConvertPath,
+		"sample.webp": converter.DwebpPath,
+	} {
+		t.Run(filename, func(t *testing.T) {
+			if _, err := exec.LookPath(decoder); err != nil {
+				skipWithoutTools(t, converter.ConvertPath)
+			}
+			filename := testdataCopy(t, filename)
+			format, err := DetectImageFormat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := converter.Decode(context.Background(), filename, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, err = DetectImageFormat(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != ImageFormatPng {
+				t.Errorf("%s is decoded into '%s', expected png", filename, format)
+			}
+		})
+	}
+}
+
+func TestConverter_IsAnimatedImage(t *testing.T) {
+	converter := NewConverter()
+	if converter.IsAnimatedImage(context.Background(), path.Join("testdata", "sample.jpg")) {
+		t.Errorf("jpeg is considered animated")
+	}
+
+	skipWithoutTools(t, converter.IdentifyPath)
+	for filename, animated := range map[string]bool{
+		"sample.webp":   false,
+		"animated.webp": true,
+		"sample.png":    false,
+	} {
+		if converter.IsAnimatedImage(context.Background(), path.Join("testdata", filename)) != animated {
+			t.Errorf("%s is expected to be animated=%t", filename, animated)
+		}
+	}
+}
+
+func TestConverter_ImageTelegram(t *testing.T) {
+	converter := NewConverter()
+	skipWithoutTools(t, converter.ConvertPath, converter.IdentifyPath)
+
+	for _, filename := range []string{"sample.jpg", "sample.heic", "sample.avif", "sample.webp"} {
+		t.Run(filename, func(t *testing.T) {
+			filename := testdataCopy(t, filename)
+			image, err := converter.ImageTelegram(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, err := DetectImageFormat(image.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != ImageFormatJpeg && format != ImageFormatPng {
+				t.Errorf("%s is prepared as '%s', which Telegram doesn't accept as a photo", filename, format)
+			}
+			if image.Width == 0 || image.Height == 0 || image.Size > TelegramMaximumPhotoSizeAllowed {
+				t.Errorf("%s is prepared as %dx%d of %d bytes", filename, image.Width, image.Height, image.Size)
+			}
+		})
+	}
+
+	_, err := converter.ImageTelegram(context.Background(), testdataCopy(t, "sample.svg"))
+	if err == nil {
+		t.Errorf("svg isn't rejected")
+	}
+}
+
+// fakeImageTools writes convert and identify, which pretend to encode images, the first line of an image file is its
+// sizes and the jpeg is as big as the sizes and the quality make it, so the search doesn't need big images
+func fakeImageTools(t *testing.T) *Converter {
+	directory := t.TempDir()
+	tools := map[string]string{
+		"convert": `#!/bin/sh
+box=${3%>}; quality=$5; in=${6%\[0\]}; out=$7
+read sizes < "$in"; w=${sizes%x*}; h=${sizes#*x}; bw=${box%x*}; bh=${box#*x}
+if [ $((w*bh)) -gt $((h*bw)) ]; then
+	if [ $w -gt $bw ]; then h=$((h*bw/w)); w=$bw; fi
+elif [ $h -gt $bh ]; then
+	w=$((w*bh/h)); h=$bh
+fi
+echo "${w}x${h}" > "$out"
+truncate -s $((w*h*quality/70)) "$out"
+`,
+		"identify": `#!/bin/sh
+read sizes < "$1"
+case "$1" in *.jpg) echo "$1 JPEG $sizes" ;; *) echo "$1 BMP $sizes" ;; esac
+`,
+	}
+	for name, script := range tools {
+		err := os.WriteFile(path.Join(directory, name), []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewConverter(Converter{ConvertPath: path.Join(directory, "convert"), IdentifyPath: path.Join(directory, "identify")})
+}
+
+func TestConverter_imageTelegramSearch(t *testing.T) {
+	skipWithoutTools(t, "sh", "truncate")
+	converter := fakeImageTools(t)
+
+	for _, test := range []struct {
+		sizes         string
+		quality       int
+		width, height int
+	}{
+		{"200x3000", 95, 200, 3000},   // a panorama narrower, than the minimal side
+		{"100x100", 95, 100, 100},     // a small bmp
+		{"2000x2000", 87, 2000, 2000}, // 2000*2000*87/70 fits into 5MB, 88 doesn't
+		{"3840x3840", 95, 1620, 1620}, // doesn't fit at the minimal quality until it's shrunk 3 times
+	} {
+		t.Run(test.sizes, func(t *testing.T) {
+			filename := path.Join(t.TempDir(), "image.bmp")
+			err := os.WriteFile(filename, []byte(test.sizes+"\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			image, err := converter.imageTelegram(context.Background(), filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Quality != test.quality || image.Width != test.width || image.Height != test.height || image.Size > TelegramMaximumPhotoSizeAllowed {
+				t.Errorf("%s is prepared as %dx%d of %d bytes at quality %d", test.sizes, image.Width, image.Height, image.Size, image.Quality)
+			}
+		})
+	}
+}
@@ -2,16 +2,19 @@ package joi
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	tele "gopkg.in/telebot.v3"
 	"log"
+	"math/rand"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 /* This comment might be outdated, tho it should help you to understand the database structure
@@ -21,26 +24,51 @@ Redis as DB:
 			joi:bot_id:times				: set<post_time>
 			joi:bot_id:time:time_value		: set<post_id>
 			joi:bot_id:posts				: set<post_id>
+			joi:bot_id:tag:tag_value		: set<post_id>
 
 			joi:bot_id:post:id:time			: time
-			joi:bot_id:post:id:text			: post_text.md
-			joi:bot_id:post:id:comment		: comment_text.md
+			joi:bot_id:post:id:text			: post_text
+			joi:bot_id:post:id:text_entities	: text_entities.json
+			joi:bot_id:post:id:comment		: comment_text
+			joi:bot_id:post:id:comment_entities	: comment_entities.json
 			joi:bot_id:post:id:post_src		: post_sources
 			joi:bot_id:post:id:protected	: is_protected
 			joi:bot_id:post:id:files		: file_type_1 tg_file_id_1 file_type_2 tg_file_id_2...
+			joi:bot_id:post:id:converted	: converted_tg_file_id_1 converted_tg_file_id_2...
 			joi:bot_id:post:id:release_id	: msg_id_in_comments_chat_channel_posted
 			joi:bot_id:post:id:msg_ids		: admin_id msg_id_1 msg_id_2...
+			joi:bot_id:post:id:no_web_preview	: disable_web_page_preview
+			joi:bot_id:post:id:poll			: poll.json
+			joi:bot_id:post:id:buttons		: url_1 title_1 url_2 title_2...
+			joi:bot_id:post:id:origin		: origin.json
+			joi:bot_id:post:id:held			: is_held
+			joi:bot_id:post:id:tags			: tag_1 tag_2...
 			...
 
+			joi:bot_id:hashes:queued		: hash<post_id/file_index, phash>
+			joi:bot_id:hashes:published		: hash<post_id/file_index, phash link_to_posted>
+
+			joi:bot_id:poll_stops			: sorted_set<chat_id msg_id, unix_time_to_stop>
+			joi:bot_id:post_number			: number_of_published_posts
+
 			joi:bot_id:admin_id:msg_id		: post_id
 			...
 		}
 
 	Note:
-		- file_type in {photo, video, doc_photo, doc_video}
+		- file_type in {photo, video, doc_photo, doc_video, animation, doc_animation, document,
+			audio, voice, video_note, sticker}
 		- post_sources in {true, false, auto}
 		- is_protected in {true, false}
-		- post_text.md, comment_text.md - markdown strings, not actual files
+		- disable_web_page_preview in {true, false}, set for text only posts
+		- post_text, comment_text - plain text, formatted by text_entities.json, comment_entities.json - json of
+			telegram entities, both are absent for posts stored as markdown before, see MigrateFormatting
+		- converted_tg_file_id is empty, if the file hasn't been converted in advance
+		- poll.json - json of PollInfo, absent for posts without a poll
+		- origin.json - json of PostOrigin, absent unless the post is forwarded from another channel
+		- is_held in {true, false}, absent for posts added before duplicates detection
+		- phash - hex of 64-bit dHash of a photo
+		- tag - lowercase, without #
 
 	class Database:
 		func GetTimes() -> List[TimeString] or Error
@@ -48,6 +76,7 @@ Redis as DB:
 		func GetPosts() -> List[PostInfo] or Error
 		func GetPost(msg_id or post_id) -> PostInfo or Error
 		func GetPostsByTime(TimeString) -> List[PostInfo] or Error
+		func GetRandomPostByTags(TimeString, List[Tag]) -> PostInfo or Error
 
 		func AddPostFromMessages(telegram.Message...) -> PostInfo or Error
 		func ChangePost(msg_id or post_id, PostInfo) -> PostInfo or Error
@@ -93,6 +122,17 @@ func (db *Database) GetPost(id string) (post *PostInfo, err error) {
 	return db.getPostAsync(id)
 }
 
+// GetPostByMessage finds the post, the message of the admin belongs to
+func (db *Database) GetPostByMessage(adminId int64, msgId int) (post *PostInfo, err error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+	id, err := db.client.Get(redisContext, db.toKey(fmt.Sprintf("%d", adminId), fmt.Sprintf("%d", msgId))).Result()
+	if err != nil {
+		return nil, err
+	}
+	return db.getPostAsync(id)
+}
+
 func (db *Database) GetPosts() (posts []*PostInfo, err error) {
 	defer db.mutex.Unlock()
 	db.mutex.Lock()
@@ -149,8 +189,68 @@ func (db *Database) GetRandomPostByTime(t string) (post *PostInfo, err error) {
 	if err != nil {
 		return nil, err
 	}
+	post, err = db.getPostAsync(id)
+	if err != nil || !post.Held {
+		return post, err
+	}
 
-	return db.getPostAsync(id)
+	// held posts wait for the admin to override them
+	ids, err := db.client.SMembers(redisContext, db.toKey("time", t)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		post, err = db.getPostAsync(id)
+		if err != nil {
+			return nil, err
+		}
+		if !post.Held {
+			return post, nil
+		}
+	}
+	return nil, redis.Nil
+}
+
+// GetRandomPostByTags returns a random not held post for the time, which has any of the tags
+func (db *Database) GetRandomPostByTags(t string, tags []string) (*PostInfo, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	if !isTimeValid(t) {
+		return nil, errors.New(fmt.Sprintf("%s is invalid TimeString", t))
+	}
+	if len(tags) == 0 {
+		return nil, redis.Nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = db.toKey("tag", tag)
+	}
+	ids, err := db.client.SUnion(redisContext, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	rand.New(rand.NewSource(time.Now().UnixNano())).Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	for _, id := range ids {
+		isForTime, err := db.client.SIsMember(redisContext, db.toKey("time", t), id).Result()
+		if err != nil {
+			return nil, err
+		}
+		if !isForTime {
+			continue
+		}
+		post, err := db.getPostAsync(id)
+		if err != nil {
+			return nil, err
+		}
+		if !post.Held {
+			return post, nil
+		}
+	}
+	return nil, redis.Nil
 }
 
 func (db *Database) AddPost(post *PostInfo) (*PostInfo, error) {
@@ -159,15 +259,21 @@ func (db *Database) AddPost(post *PostInfo) (*PostInfo, error) {
 
 	return db.putPostAsync(post)
 }
-func (db *Database) AddPostFromMessages(base *PostInfo, msgs ...*tele.Message) (post *PostInfo, err error) {
+
+// AddPostFromMessages saves the post made of the messages, unless check rejects it
+func (db *Database) AddPostFromMessages(base *PostInfo, check PostCheck, msgs ...*tele.Message) (post *PostInfo, err error) {
 	defer db.mutex.Unlock()
 	db.mutex.Lock()
 
 	if len(msgs) == 0 {
 		return nil, errors.New("no messages provided")
 	}
-	if msgs[0].Sender == nil {
-		return nil, errors.New("broken msg is given")
+	adminPostedId := base.AdminPostedId // set for messages, which weren't sent by the admin, i.e. uploaded by the bot itself
+	if adminPostedId == 0 {
+		if msgs[0].Sender == nil {
+			return nil, errors.New("broken msg is given")
+		}
+		adminPostedId = msgs[0].Sender.ID
 	}
 
 	id := mediaGroupToId(msgs[0])
@@ -175,36 +281,77 @@ func (db *Database) AddPostFromMessages(base *PostInfo, msgs ...*tele.Message) (
 		Id:                  id,
 		Time:                base.Time,
 		Text:                base.Text,
+		TextEntities:        base.TextEntities,
 		Comment:             base.Comment,
+		CommentEntities:     base.CommentEntities,
 		PostSources:         base.PostSources,
 		IsProtected:         base.IsProtected,
 		Files:               make([]TgFileInfo, len(msgs)),
 		MsgIdInCommentsChat: base.MsgIdInCommentsChat,
-		AdminPostedId:       msgs[0].Sender.ID,
+		AdminPostedId:       adminPostedId,
 		OriginalMsgIds:      make([]int64, len(msgs)),
+
+		DisableWebPagePreview: base.DisableWebPagePreview,
+		Poll:                  base.Poll,
+		Buttons:               base.Buttons,
+		Origin:                base.Origin,
+		Held:                  base.Held,
+		Tags:                  mergeTags(base.Tags, tagsOfText(base.Text, base.Comment)),
 	}
 
 	for i, msg := range msgs {
 		post.OriginalMsgIds[i] = int64(msg.ID)
 
 		switch {
+		case len(msgs) == 1 && msg.Text != "":
+			post.Files = []TgFileInfo{} // text only post, the text itself is provided by base
+		case len(msgs) == 1 && msg.Poll != nil:
+			post.Files = []TgFileInfo{}
+			post.Poll = pollInfoFromTelegram(msg.Poll)
 		case msg.Photo != nil:
-			post.Files[i] = TgFileInfo{TelegramFileTypePhoto, msg.Photo.FileID}
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypePhoto, Id: msg.Photo.FileID}
 		case msg.Video != nil:
-			post.Files[i] = TgFileInfo{TelegramFileTypeVideo, msg.Video.FileID}
-		case msg.Document != nil && strings.HasPrefix(strings.ToLower(msg.Document.MIME), "image"):
-			post.Files[i] = TgFileInfo{TelegramFileTypeDocPhoto, msg.Document.FileID}
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeVideo, Id: msg.Video.FileID}
+		case msg.Animation != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeAnimation, Id: msg.Animation.FileID}
+		case msg.Audio != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeAudio, Id: msg.Audio.FileID}
+		case msg.Voice != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeVoice, Id: msg.Voice.FileID}
+		case msg.VideoNote != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeVideoNote, Id: msg.VideoNote.FileID}
+		case msg.Sticker != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeSticker, Id: msg.Sticker.FileID}
+		case msg.Document != nil && imageFormatOfDocument(msg.Document.MIME, msg.Document.FileName) != "":
+			format := imageFormatOfDocument(msg.Document.MIME, msg.Document.FileName)
+			if !IsImageFormatSupported(format) {
+				return nil, errors.New(fmt.Sprintf("%s images (%s) are not supported, supported ones are %s",
+					format, msg.Document.FileName, strings.Join(supportedImageFormats, ", ")))
+			}
+			if format == ImageFormatGif {
+				post.Files[i] = TgFileInfo{Type: TelegramFileTypeDocAnimation, Id: msg.Document.FileID}
+			} else {
+				post.Files[i] = TgFileInfo{Type: TelegramFileTypeDocPhoto, Id: msg.Document.FileID}
+			}
 		case msg.Document != nil && strings.HasPrefix(strings.ToLower(msg.Document.MIME), "video"):
 			if msg.Document.FileSize < 50_000_000 {
-				post.Files[i] = TgFileInfo{TelegramFileTypeDocVideo, msg.Document.FileID}
+				post.Files[i] = TgFileInfo{Type: TelegramFileTypeDocVideo, Id: msg.Document.FileID}
 			} else {
 				return nil, errors.New(fmt.Sprintf("File of size %.2f MB is too big (max - 50MB)",
 					float64(msg.Document.FileSize)/1_000_000.0))
 			}
+		case msg.Document != nil:
+			post.Files[i] = TgFileInfo{Type: TelegramFileTypeDocument, Id: msg.Document.FileID}
 		default:
 			return nil, errors.New("message with no supported media is provided")
 		}
 	}
+	if check != nil {
+		err = check(post)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return db.putPostAsync(post)
 }
@@ -226,15 +373,35 @@ func (db *Database) ChangePost(id string, what int, value interface{}) (*PostInf
 		}
 		post.Time = newTime
 	case ChangePostText:
-		post.Text = value.(string)
+		post.Text, post.TextEntities = value.(FormattedText).Text, value.(FormattedText).Entities
 	case ChangePostComment:
-		post.Comment = value.(string)
+		post.Comment, post.CommentEntities = value.(FormattedText).Text, value.(FormattedText).Entities
 	case ChangePostIsProtected:
 		post.IsProtected = value.(bool)
 	case ChangePostPostSources:
 		post.PostSources = value.(int)
 	case ChangePostMsgIdInCommentsChat:
 		post.MsgIdInCommentsChat = value.(int)
+	case ChangePostDisableWebPagePreview:
+		post.DisableWebPagePreview = value.(bool)
+	case ChangePostButtons:
+		post.Buttons = value.([]PostButton)
+	case ChangePostHeld:
+		post.Held = value.(bool)
+	case ChangePostTags:
+		post.Tags = value.([]string)
+	case ChangePostConvertedFile:
+		converted := value.(TgFileInfo)
+		for i := range post.Files {
+			if post.Files[i].Id == converted.Id {
+				post.Files[i].ConvertedId = converted.ConvertedId
+				post.Files[i].Type = converted.Type // an animated image turns into an animation
+			}
+		}
+	}
+
+	if !isPostInfoValid(post) {
+		return nil, errors.New("the post would become invalid, therefore nothing is changed")
 	}
 
 	err = db.remPostAsync(id)
@@ -245,6 +412,293 @@ func (db *Database) ChangePost(id string, what int, value interface{}) (*PostInf
 	return db.putPostAsync(post)
 }
 
+// MergePosts appends files of the other post to the post, the other post is removed
+func (db *Database) MergePosts(id string, otherId string, check PostCheck) (*PostInfo, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	if id == otherId {
+		return nil, errors.New("can't merge the post with itself")
+	}
+	post, err := db.getRearrangeablePostAsync(id)
+	if err != nil {
+		return nil, err
+	}
+	other, err := db.getRearrangeablePostAsync(otherId)
+	if err != nil {
+		return nil, err
+	}
+	if post.AdminPostedId != other.AdminPostedId {
+		return nil, errors.New("posts added by different admins can't be merged")
+	}
+	dropped := droppedByMerge(post, other)
+	if len(dropped) > 0 {
+		return nil, errors.New(fmt.Sprintf("%s of post %s would be lost, remove them first", strings.Join(dropped, ", "), otherId))
+	}
+
+	merged := *post
+	merged.Files = append(append([]TgFileInfo{}, post.Files...), other.Files...)
+	merged.OriginalMsgIds = append(append([]int64{}, post.OriginalMsgIds...), other.OriginalMsgIds...)
+	merged.Held = post.Held || other.Held
+	merged.Tags = mergeTags(post.Tags, other.Tags)
+
+	posts, err := db.rearrangePostsAsync([]*PostInfo{post, other}, []*PostInfo{&merged}, check)
+	if err != nil {
+		return nil, err
+	}
+	return posts[0], nil
+}
+
+// droppedByMerge lists what the other post has, but the post merged with it wouldn't
+func droppedByMerge(post *PostInfo, other *PostInfo) []string {
+	dropped := make([]string, 0)
+	if other.Text != "" {
+		dropped = append(dropped, "text")
+	}
+	if other.Comment != "" {
+		dropped = append(dropped, "comment")
+	}
+	if other.Poll != nil {
+		dropped = append(dropped, "poll")
+	}
+	for _, button := range other.Buttons {
+		if !containsButton(post.Buttons, button) {
+			dropped = append(dropped, "buttons")
+			break
+		}
+	}
+	return dropped
+}
+
+func containsButton(buttons []PostButton, button PostButton) bool {
+	for _, b := range buttons {
+		if b == button {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPost moves files starting from the index (from 0) to a new post, which gets no text, comment and time
+func (db *Database) SplitPost(id string, at int, check PostCheck) (*PostInfo, *PostInfo, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	post, err := db.getRearrangeablePostAsync(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if at <= 0 || at >= len(post.Files) {
+		return nil, nil, errors.New(fmt.Sprintf("the post of %d items can be split only from item 2 to %d", len(post.Files), len(post.Files)))
+	}
+
+	first := *post
+	first.Files = append([]TgFileInfo{}, post.Files[:at]...)
+	first.OriginalMsgIds = append([]int64{}, post.OriginalMsgIds[:at]...)
+
+	second := *post
+	second.Id = fmt.Sprintf("%d_%d", post.AdminPostedId, post.OriginalMsgIds[at])
+	second.Time = TimeIsNotSpecified
+	second.Text, second.TextEntities = "", nil
+	second.Comment, second.CommentEntities = "", nil
+	second.MsgIdInCommentsChat = 0
+	second.Poll = nil
+	second.Files = append([]TgFileInfo{}, post.Files[at:]...)
+	second.OriginalMsgIds = append([]int64{}, post.OriginalMsgIds[at:]...)
+
+	posts, err := db.rearrangePostsAsync([]*PostInfo{post}, []*PostInfo{&first, &second}, check)
+	if err != nil {
+		return nil, nil, err
+	}
+	return posts[0], posts[1], nil
+}
+
+// ReorderPost rearranges files of the post, order is a permutation of indexes (from 0) of all the files
+func (db *Database) ReorderPost(id string, order []int, check PostCheck) (*PostInfo, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	post, err := db.getRearrangeablePostAsync(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(order) != len(post.Files) {
+		return nil, errors.New(fmt.Sprintf("order of all the %d items must be given", len(post.Files)))
+	}
+
+	reordered := *post
+	reordered.Files = make([]TgFileInfo, len(order))
+	reordered.OriginalMsgIds = make([]int64, len(order))
+	used := make([]bool, len(order))
+	for i, index := range order {
+		if index < 0 || index >= len(order) || used[index] {
+			return nil, errors.New(fmt.Sprintf("item %d is missing or repeated", index+1))
+		}
+		used[index] = true
+		reordered.Files[i] = post.Files[index]
+		reordered.OriginalMsgIds[i] = post.OriginalMsgIds[index]
+	}
+
+	posts, err := db.rearrangePostsAsync([]*PostInfo{post}, []*PostInfo{&reordered}, check)
+	if err != nil {
+		return nil, err
+	}
+	return posts[0], nil
+}
+
+// DropPostItem removes the file (index from 0) from the post
+func (db *Database) DropPostItem(id string, index int, check PostCheck) (*PostInfo, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	post, err := db.getRearrangeablePostAsync(id)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(post.Files) {
+		return nil, errors.New(fmt.Sprintf("there's no item %d in the post of %d items", index+1, len(post.Files)))
+	}
+	if len(post.Files) == 1 {
+		return nil, errors.New("that's the only item of the post, remove the whole post instead")
+	}
+
+	dropped := *post
+	dropped.Files = append(append([]TgFileInfo{}, post.Files[:index]...), post.Files[index+1:]...)
+	dropped.OriginalMsgIds = append(append([]int64{}, post.OriginalMsgIds[:index]...), post.OriginalMsgIds[index+1:]...)
+
+	posts, err := db.rearrangePostsAsync([]*PostInfo{post}, []*PostInfo{&dropped}, check)
+	if err != nil {
+		return nil, err
+	}
+	return posts[0], nil
+}
+
+// getRearrangeablePostAsync gets the post, which files could be moved around,
+// that is every file has its own original message
+func (db *Database) getRearrangeablePostAsync(id string) (*PostInfo, error) {
+	post, err := db.getPostAsync(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(post.Files) == 0 || len(post.Files) != len(post.OriginalMsgIds) {
+		return nil, errors.New(fmt.Sprintf("post %s has no files to rearrange", id))
+	}
+	return post, nil
+}
+
+// PostCheck rejects the post, which shouldn't be saved, i.e. its text is too long for it
+type PostCheck func(post *PostInfo) error
+
+// rearrangePostsAsync replaces the old posts with the new ones made of the same files, unless check rejects any of them,
+// message id -> post id mappings and media hashes follow the original messages of the files
+func (db *Database) rearrangePostsAsync(old []*PostInfo, new []*PostInfo, check PostCheck) ([]*PostInfo, error) {
+	oldIds := map[string]bool{}
+	for _, post := range old {
+		oldIds[post.Id] = true
+	}
+	for _, post := range new {
+		if !isPostInfoValid(post) {
+			return nil, errors.New("the post would become invalid, therefore nothing is changed")
+		}
+		if check != nil {
+			err := check(post)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s\ntherefore nothing is changed", err.Error()))
+			}
+		}
+		if !oldIds[post.Id] {
+			contains, err := db.containsPostAsync(post.Id)
+			if err != nil {
+				return nil, err
+			}
+			if contains {
+				return nil, errors.New(fmt.Sprintf("post with id %s already exists", post.Id))
+			}
+		}
+	}
+
+	for _, post := range old {
+		err := db.remPostAsync(post.Id)
+		if err != nil {
+			log.Printf("warning: while removing %s, errors occured:\n%s", post.Id, err.Error())
+		}
+	}
+	posts := make([]*PostInfo, 0, len(new))
+	for _, post := range new {
+		put, err := db.putPostAsync(post)
+		if err != nil {
+			db.restorePostsAsync(old, new)
+			return nil, errors.New(fmt.Sprintf("%s\ntherefore nothing is changed", err.Error()))
+		}
+		posts = append(posts, put)
+	}
+
+	err := db.moveMediaHashesAsync(old, new)
+	if err != nil {
+		log.Printf("warning: while moving media hashes of %s, errors occured:\n%s", old[0].Id, err.Error())
+	}
+	return posts, nil
+}
+
+// restorePostsAsync puts the old posts back in place of the new ones, which are failed to be put, maybe partially
+func (db *Database) restorePostsAsync(old []*PostInfo, new []*PostInfo) {
+	for _, post := range new {
+		// the partially put post might be missing from the posts, then remPostAsync wouldn't remove its fields
+		err := db.client.SAdd(redisContext, db.toKey("posts"), post.Id).Err()
+		if err == nil {
+			err = db.remPostAsync(post.Id)
+		}
+		if err == nil {
+			err = db.client.SRem(redisContext, db.toKey("time", post.Time), post.Id).Err()
+		}
+		if err != nil {
+			log.Printf("warning: while removing partially put %s, errors occured:\n%s", post.Id, err.Error())
+		}
+	}
+	for _, post := range old {
+		_, err := db.putPostAsync(post)
+		if err != nil {
+			log.Printf("error: post %s is lost, while restoring it an error occured %s", post.Id, err.Error())
+		}
+	}
+}
+
+// moveMediaHashesAsync makes hashes of the queued files follow their original messages from the old posts to the new ones
+func (db *Database) moveMediaHashesAsync(old []*PostInfo, new []*PostInfo) error {
+	newFields := map[int64]string{} // original message id -> field of the media hash
+	for _, post := range new {
+		for i, msgId := range post.OriginalMsgIds {
+			newFields[msgId] = fmt.Sprintf("%s/%d", post.Id, i)
+		}
+	}
+	queued, err := db.client.HGetAll(redisContext, db.toKey("hashes", "queued")).Result()
+	if err != nil {
+		return err
+	}
+	hashes := map[string]string{}
+	for _, post := range old {
+		for i, msgId := range post.OriginalMsgIds {
+			oldField := fmt.Sprintf("%s/%d", post.Id, i)
+			if hash, contains := queued[oldField]; contains {
+				err = db.client.HDel(redisContext, db.toKey("hashes", "queued"), oldField).Err()
+				if err != nil {
+					return err
+				}
+				if newField, contains := newFields[msgId]; contains {
+					hashes[newField] = hash
+				}
+			}
+		}
+	}
+	for field, hash := range hashes {
+		err = db.client.HSet(redisContext, db.toKey("hashes", "queued"), field, hash).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *Database) containsPostAsync(id string) (bool, error) {
 	return db.client.SIsMember(redisContext, db.toKey("posts"), id).Result()
 }
@@ -258,7 +712,166 @@ func (db *Database) ContainsPost(id string) (bool, error) {
 func (db *Database) RemovePost(id string) (err error) {
 	defer db.mutex.Unlock()
 	db.mutex.Lock()
-	return db.remPostAsync(id)
+	err = db.remPostAsync(id)
+	if err != nil {
+		return err
+	}
+	return db.removeMediaHashesAsync(id)
+}
+
+// SimilarMedia is a photo found by its perceptual hash
+type SimilarMedia struct {
+	PostId   string
+	Index    int // of the file in the post
+	Distance int
+	Link     string // to the posted message, empty for queued posts
+}
+
+// AddMediaHash indexes the perceptual hash of the file of the queued post
+func (db *Database) AddMediaHash(postId string, index int, hash uint64) error {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+	return db.client.HSet(redisContext, db.toKey("hashes", "queued"), fmt.Sprintf("%s/%d", postId, index), formatHash(hash)).Err()
+}
+
+// PublishMediaHashes moves hashes of the post to the published history, linked to the posted message
+func (db *Database) PublishMediaHashes(postId string, link string) error {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	queued, err := db.client.HGetAll(redisContext, db.toKey("hashes", "queued")).Result()
+	if err != nil {
+		return err
+	}
+	for field, hash := range queued {
+		if !strings.HasPrefix(field, postId+"/") {
+			continue
+		}
+		err = db.client.HSet(redisContext, db.toKey("hashes", "published"), field, fmt.Sprintf("%s %s", hash, link)).Err()
+		if err != nil {
+			return err
+		}
+		err = db.client.HDel(redisContext, db.toKey("hashes", "queued"), field).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindSimilarMedia looks for photos of queued and published posts (except the given one) within the distance from the hash
+func (db *Database) FindSimilarMedia(hash uint64, maxDistance int, excludePostId string) ([]SimilarMedia, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	similar := make([]SimilarMedia, 0)
+	for _, kind := range []string{"queued", "published"} {
+		hashes, err := db.client.HGetAll(redisContext, db.toKey("hashes", kind)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range hashes {
+			separator := strings.LastIndex(field, "/")
+			if separator < 0 || field[:separator] == excludePostId {
+				continue
+			}
+			index, err := strconv.Atoi(field[separator+1:])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s\nfor hash of '%s'", err.Error(), field))
+			}
+			hashAndLink := strings.SplitN(value, " ", 2)
+			otherHash, err := parseHash(hashAndLink[0])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s\nfor hash of '%s'", err.Error(), field))
+			}
+			if distance := hashDistance(hash, otherHash); distance <= maxDistance {
+				media := SimilarMedia{PostId: field[:separator], Index: index, Distance: distance}
+				if len(hashAndLink) > 1 {
+					media.Link = hashAndLink[1]
+				}
+				similar = append(similar, media)
+			}
+		}
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar, nil
+}
+
+func (db *Database) removeMediaHashesAsync(postId string) error {
+	queued, err := db.client.HGetAll(redisContext, db.toKey("hashes", "queued")).Result()
+	if err != nil {
+		return err
+	}
+	for field := range queued {
+		if strings.HasPrefix(field, postId+"/") {
+			err = db.client.HDel(redisContext, db.toKey("hashes", "queued"), field).Err()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddPollStop remembers to stop the posted poll at the given time, it survives restarts of the bot
+func (db *Database) AddPollStop(chatId int64, msgId int, at time.Time) error {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+	return db.client.ZAdd(redisContext, db.toKey("poll_stops"), &redis.Z{
+		Score:  float64(at.Unix()),
+		Member: fmt.Sprintf("%d %d", chatId, msgId),
+	}).Err()
+}
+
+// PopDuePollStops returns and forgets the polls, which should be stopped by now
+func (db *Database) PopDuePollStops(now time.Time) ([]tele.StoredMessage, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	max := strconv.FormatInt(now.Unix(), 10)
+	members, err := db.client.ZRangeByScore(redisContext, db.toKey("poll_stops"), &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil {
+		return nil, err
+	}
+	err = db.client.ZRemRangeByScore(redisContext, db.toKey("poll_stops"), "-inf", max).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	polls := make([]tele.StoredMessage, 0, len(members))
+	for _, member := range members {
+		chatAndMsgId := strings.SplitN(member, " ", 2)
+		if len(chatAndMsgId) < 2 {
+			return nil, errors.New(fmt.Sprintf("'%s' poll stop is invalid formatted", member))
+		}
+		chatId, err := strconv.ParseInt(chatAndMsgId[0], 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s\nfor poll stop '%s'", err.Error(), member))
+		}
+		polls = append(polls, tele.StoredMessage{ChatID: chatId, MessageID: chatAndMsgId[1]})
+	}
+	return polls, nil
+}
+
+// NextPostNumber counts the post being published and returns its number
+func (db *Database) NextPostNumber() (int64, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+	return db.client.Incr(redisContext, db.toKey("post_number")).Result()
+}
+
+// PeekPostNumber returns the number, the next published post will have
+func (db *Database) PeekPostNumber() (int64, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+	number, err := db.client.Get(redisContext, db.toKey("post_number")).Int64()
+	if err != nil && !IsErrRedisNotFound(err) {
+		return 0, err
+	}
+	return number + 1, nil
 }
 
 func mediaGroupToId(msg *tele.Message) string {
@@ -275,8 +888,9 @@ func isTimeValid(t string) bool {
 }
 
 func isPostInfoValid(info *PostInfo) bool {
-	return info != nil && info.Id != "" && isTimeValid(info.Time) && len(info.Text) < 4096 && len(info.Comment) < 4096 && info.AdminPostedId != 0 &&
-		info.PostSources >= 0 && info.PostSources <= 3 && len(info.Files) > 0 && info.MsgIdInCommentsChat >= 0 && len(info.OriginalMsgIds) > 0
+	return info != nil && info.Id != "" && isTimeValid(info.Time) && utf16Length(info.Text) <= TelegramMaximumMessageLength &&
+		utf16Length(info.Comment) <= TelegramMaximumMessageLength && info.AdminPostedId != 0 &&
+		info.PostSources >= 0 && info.PostSources <= 3 && (len(info.Files) > 0 || info.Text != "" || info.Poll != nil) && info.MsgIdInCommentsChat >= 0 && len(info.OriginalMsgIds) > 0
 }
 
 func (db *Database) getPostAsync(id string) (post *PostInfo, err error) {
@@ -304,6 +918,14 @@ func (db *Database) getPostAsync(id string) (post *PostInfo, err error) {
 	if err != nil {
 		return nil, err
 	}
+	post.TextEntities, err = db.getEntitiesAsync(db.toKey("post", id, "text_entities"))
+	if err != nil {
+		return nil, err
+	}
+	post.CommentEntities, err = db.getEntitiesAsync(db.toKey("post", id, "comment_entities"))
+	if err != nil {
+		return nil, err
+	}
 	post.PostSources, err = db.client.Get(redisContext, db.toKey("post", id, "post_sources")).Int()
 	if err != nil {
 		return nil, err
@@ -318,15 +940,25 @@ func (db *Database) getPostAsync(id string) (post *PostInfo, err error) {
 	}
 	post.Files = make([]TgFileInfo, len(fileInfos))
 	for i, info := range fileInfos {
-		if len(info) < 3 {
+		typeAndId := strings.SplitN(info, " ", 2)
+		if len(typeAndId) < 2 || typeAndId[1] == "" {
 			return nil, errors.New(fmt.Sprintf("'%s' file info is invalid formatted", info))
 		}
 
-		post.Files[i].Type, err = strconv.Atoi(info[:1])
+		post.Files[i].Type, err = strconv.Atoi(typeAndId[0])
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("%s\nfor file info '%s'", err.Error(), info))
 		}
-		post.Files[i].Id = info[2:]
+		post.Files[i].Id = typeAndId[1]
+	}
+	convertedIds, err := db.client.LRange(redisContext, db.toKey("post", id, "converted"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(convertedIds) == len(post.Files) {
+		for i, convertedId := range convertedIds {
+			post.Files[i].ConvertedId = convertedId
+		}
 	}
 	post.MsgIdInCommentsChat, err = db.client.Get(redisContext, db.toKey("post", id, "release_id")).Int()
 	if err != nil {
@@ -347,8 +979,54 @@ func (db *Database) getPostAsync(id string) (post *PostInfo, err error) {
 			return nil, errors.New(fmt.Sprintf("%s\nfor file info '%s'", err.Error(), info))
 		}
 	}
+	post.DisableWebPagePreview, err = db.client.Get(redisContext, db.toKey("post", id, "no_web_preview")).Bool()
+	if err != nil && !IsErrRedisNotFound(err) { // posts added before text only posts existed don't have it
+		return nil, err
+	}
+	pollJson, err := db.client.Get(redisContext, db.toKey("post", id, "poll")).Result()
+	if err != nil && !IsErrRedisNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		post.Poll = &PollInfo{}
+		err = json.Unmarshal([]byte(pollJson), post.Poll)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s\nfor poll '%s'", err.Error(), pollJson))
+		}
+	}
+	buttons, err := db.client.LRange(redisContext, db.toKey("post", id, "buttons"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	post.Buttons = make([]PostButton, len(buttons))
+	for i, button := range buttons {
+		urlAndTitle := strings.SplitN(button, " ", 2)
+		if len(urlAndTitle) < 2 {
+			return nil, errors.New(fmt.Sprintf("'%s' button is invalid formatted", button))
+		}
+		post.Buttons[i] = PostButton{URL: urlAndTitle[0], Title: urlAndTitle[1]}
+	}
+	originJson, err := db.client.Get(redisContext, db.toKey("post", id, "origin")).Result()
+	if err != nil && !IsErrRedisNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		post.Origin = &PostOrigin{}
+		err = json.Unmarshal([]byte(originJson), post.Origin)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s\nfor origin '%s'", err.Error(), originJson))
+		}
+	}
+	post.Held, err = db.client.Get(redisContext, db.toKey("post", id, "held")).Bool()
+	if err != nil && !IsErrRedisNotFound(err) {
+		return nil, err
+	}
+	post.Tags, err = db.client.LRange(redisContext, db.toKey("post", id, "tags"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
 
-	return
+	return post, nil
 }
 
 func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
@@ -387,6 +1065,14 @@ func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
 	if err != nil {
 		return nil, err
 	}
+	err = db.setEntitiesAsync(db.toKey("post", id, "text_entities"), new.TextEntities)
+	if err != nil {
+		return nil, err
+	}
+	err = db.setEntitiesAsync(db.toKey("post", id, "comment_entities"), new.CommentEntities)
+	if err != nil {
+		return nil, err
+	}
 	err = db.client.Set(redisContext, db.toKey("post", id, "post_sources"), new.PostSources, 0).Err()
 	if err != nil {
 		return nil, err
@@ -400,6 +1086,10 @@ func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
 		if err != nil {
 			return nil, err
 		}
+		err = db.client.RPush(redisContext, db.toKey("post", id, "converted"), fileInfo.ConvertedId).Err()
+		if err != nil {
+			return nil, err
+		}
 	}
 	err = db.client.Set(redisContext, db.toKey("post", id, "release_id"), new.MsgIdInCommentsChat, 0).Err()
 	if err != nil {
@@ -415,6 +1105,46 @@ func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
 			return nil, err
 		}
 	}
+	err = db.client.Set(redisContext, db.toKey("post", id, "no_web_preview"), fmt.Sprintf("%t", new.DisableWebPagePreview), 0).Err()
+	if err != nil {
+		return nil, err
+	}
+	if new.Poll != nil {
+		pollJson, err := json.Marshal(new.Poll)
+		if err != nil {
+			return nil, err
+		}
+		err = db.client.Set(redisContext, db.toKey("post", id, "poll"), pollJson, 0).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, button := range new.Buttons {
+		err = db.client.RPush(redisContext, db.toKey("post", id, "buttons"), fmt.Sprintf("%s %s", button.URL, button.Title)).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if new.Origin != nil {
+		originJson, err := json.Marshal(new.Origin)
+		if err != nil {
+			return nil, err
+		}
+		err = db.client.Set(redisContext, db.toKey("post", id, "origin"), originJson, 0).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = db.client.Set(redisContext, db.toKey("post", id, "held"), fmt.Sprintf("%t", new.Held), 0).Err()
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range new.Tags {
+		err = db.client.RPush(redisContext, db.toKey("post", id, "tags"), tag).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// side effects //
 
@@ -433,6 +1163,13 @@ func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
 		return nil, err
 	}
 
+	for _, tag := range new.Tags {
+		err = db.client.SAdd(redisContext, db.toKey("tag", tag), new.Id).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, msgId := range new.OriginalMsgIds {
 		err = db.client.Set(redisContext, db.toKey(fmt.Sprintf("%d", new.AdminPostedId), fmt.Sprintf("%d", msgId)), new.Id, 0).Err()
 		if err != nil {
@@ -443,6 +1180,84 @@ func (db *Database) putPostAsync(new *PostInfo) (post *PostInfo, err error) {
 	return db.getPostAsync(id)
 }
 
+// getEntitiesAsync reads entities stored as json, posts stored before entities existed don't have them
+func (db *Database) getEntitiesAsync(key string) (tele.Entities, error) {
+	entitiesJson, err := db.client.Get(redisContext, key).Result()
+	if IsErrRedisNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entities tele.Entities
+	err = json.Unmarshal([]byte(entitiesJson), &entities)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s\nfor entities '%s'", err.Error(), entitiesJson))
+	}
+	return entities, nil
+}
+
+// setEntitiesAsync stores entities as json, even empty ones, so the post is known to be stored as plain text
+func (db *Database) setEntitiesAsync(key string, entities tele.Entities) error {
+	if entities == nil {
+		entities = tele.Entities{}
+	}
+	entitiesJson, err := json.Marshal(entities)
+	if err != nil {
+		return err
+	}
+	return db.client.Set(redisContext, key, entitiesJson, 0).Err()
+}
+
+// MigrateFormatting turns text and comment of the posts stored as markup (before entities existed) into plain text
+// with entities, the markup is parsed with the first of the parse modes it is valid for, it's taken as plain text otherwise.
+// Returns the number of migrated posts.
+func (db *Database) MigrateFormatting(parseModes ...string) (int, error) {
+	defer db.mutex.Unlock()
+	db.mutex.Lock()
+
+	ids, err := db.client.SMembers(redisContext, db.toKey("posts")).Result()
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, id := range ids {
+		stored, err := db.client.Exists(redisContext, db.toKey("post", id, "text_entities")).Result()
+		if err != nil {
+			return migrated, err
+		}
+		if stored > 0 {
+			continue
+		}
+
+		// the text goes last, since its entities mark the post as migrated
+		for _, field := range []string{"comment", "text"} {
+			markup, err := db.client.Get(redisContext, db.toKey("post", id, field)).Result()
+			if err != nil && !IsErrRedisNotFound(err) {
+				return migrated, err
+			}
+			formatted := FormattedText{Text: markup}
+			for _, parseMode := range parseModes {
+				parsed, err := parseMarkup(markup, parseMode)
+				if err == nil {
+					formatted = parsed
+					break
+				}
+			}
+			err = db.client.Set(redisContext, db.toKey("post", id, field), formatted.Text, 0).Err()
+			if err != nil {
+				return migrated, err
+			}
+			err = db.setEntitiesAsync(db.toKey("post", id, field+"_entities"), formatted.Entities)
+			if err != nil {
+				return migrated, err
+			}
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
 func (db *Database) remPostAsync(id string) error {
 	delPost, _ := db.getPostAsync(id)
 
@@ -472,6 +1287,10 @@ func (db *Database) remPostAsync(id string) error {
 	if err != nil {
 		occurredErrors = append(occurredErrors, err.Error())
 	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "text_entities"), db.toKey("post", id, "comment_entities")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
 	err = db.client.Del(redisContext, db.toKey("post", id, "post_sources")).Err()
 	if err != nil {
 		occurredErrors = append(occurredErrors, err.Error())
@@ -484,6 +1303,10 @@ func (db *Database) remPostAsync(id string) error {
 	if err != nil {
 		occurredErrors = append(occurredErrors, err.Error())
 	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "converted")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
 	err = db.client.Del(redisContext, db.toKey("post", id, "release_id")).Err()
 	if err != nil {
 		occurredErrors = append(occurredErrors, err.Error())
@@ -492,6 +1315,30 @@ func (db *Database) remPostAsync(id string) error {
 	if err != nil {
 		occurredErrors = append(occurredErrors, err.Error())
 	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "no_web_preview")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "poll")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "buttons")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "origin")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "held")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
+	err = db.client.Del(redisContext, db.toKey("post", id, "tags")).Err()
+	if err != nil {
+		occurredErrors = append(occurredErrors, err.Error())
+	}
 
 	// side effects //
 
@@ -518,6 +1365,12 @@ func (db *Database) remPostAsync(id string) error {
 				occurredErrors = append(occurredErrors, err.Error())
 			}
 		}
+		for _, tag := range delPost.Tags {
+			err = db.client.SRem(redisContext, db.toKey("tag", tag), delPost.Id).Err()
+			if err != nil {
+				occurredErrors = append(occurredErrors, err.Error())
+			}
+		}
 		if len(delPost.OriginalMsgIds) > 0 && delPost.AdminPostedId != 0 {
 			for _, msgId := range delPost.OriginalMsgIds {
 				err = db.client.Del(redisContext, db.toKey(fmt.Sprintf("%d", delPost.AdminPostedId), fmt.Sprintf("%d", msgId))).Err()
@@ -3,6 +3,7 @@ package joi
 import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	tele "gopkg.in/telebot.v3"
 	"strings"
 	"testing"
 )
@@ -23,8 +24,8 @@ var (
 		Comment:     "test comment 1111",
 		PostSources: PostSourcesTrue,
 		IsProtected: true,
-		Files: []TgFileInfo{{TelegramFileTypePhoto, "kitty photo 1111"},
-			{TelegramFileTypeVideo, "corgi video 1111"}},
+		Files: []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "kitty photo 1111"},
+			{Type: TelegramFileTypeVideo, Id: "corgi video 1111"}},
 		MsgIdInCommentsChat: 777,
 		AdminPostedId:       1000,
 		OriginalMsgIds:      []int64{7, 8},
@@ -36,8 +37,8 @@ var (
 		Comment:     "test comment 2222",
 		PostSources: PostSourcesTrue,
 		IsProtected: true,
-		Files: []TgFileInfo{{TelegramFileTypeDocPhoto, "kitty photo 2222"},
-			{TelegramFileTypeDocVideo, "corgi video 2222"}},
+		Files: []TgFileInfo{{Type: TelegramFileTypeDocPhoto, Id: "kitty photo 2222"},
+			{Type: TelegramFileTypeDocVideo, Id: "corgi video 2222"}},
 		MsgIdInCommentsChat: 0,
 		AdminPostedId:       1000,
 		OriginalMsgIds:      []int64{12, 13},
@@ -120,6 +121,41 @@ func TestDatabase_AddPost(t *testing.T) {
 	}
 }
 
+func TestDatabase_AddPostFromMessagesCheck(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	joi := &Joi{}
+	msg := &tele.Message{ID: 5, Chat: &tele.Chat{ID: 1000}, Sender: &tele.User{ID: 1000}, Photo: &tele.Photo{File: tele.File{FileID: "photo"}}}
+	base := &PostInfo{Time: TimeIsNotSpecified, Text: strings.Repeat("a", TelegramMaximumCaptionLength+1)}
+	_, err = db.AddPostFromMessages(base, joi.checkPost, msg)
+	if err == nil {
+		t.Fatal("the post with the text longer, than the caption allows, isn't rejected")
+	}
+	posts, err := db.GetPosts()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(posts) != 0 {
+		t.Fatalf("the rejected post is saved: %+v", posts[0])
+	}
+	if _, err = db.GetPostByMessage(1000, msg.ID); err == nil {
+		t.Fatal("the message of the rejected post is linked to it")
+	}
+
+	base.Text = "fits"
+	post, err := db.AddPostFromMessages(base, joi.checkPost, msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = db.GetPostByMessage(1000, msg.ID); err != nil {
+		t.Fatalf("the accepted post %s isn't found by its message: %s", post.Id, err.Error())
+	}
+}
+
 func TestDatabase_RemovePost(t *testing.T) {
 	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
 	err := db.client.FlushDB(redisContext).Err()
@@ -225,3 +261,291 @@ func TestDatabase_ChangePost(t *testing.T) {
 		t.Fatalf("reason of inequality if wrong: %s", reason)
 	}
 }
+
+func TestDatabase_RearrangePosts(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = db.AddPost(&testPost1111)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = db.AddPost(&testPostNA)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = db.AddMediaHash(testPostNA.Id, 0, 0xff)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = db.MergePosts(testPost1111.Id, testPostNA.Id, nil)
+	if err == nil || !strings.Contains(err.Error(), "text, comment") {
+		t.Fatalf("merge losing text and comment of the other post isn't refused: %v", err)
+	}
+	for _, what := range []int{ChangePostText, ChangePostComment} {
+		_, err = db.ChangePost(testPostNA.Id, what, FormattedText{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	merged, err := db.MergePosts(testPost1111.Id, testPostNA.Id, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(merged.Files) != 4 || merged.Files[2].Id != "kitty photo 2222" || merged.OriginalMsgIds[3] != 13 {
+		t.Fatalf("merged post is wrong: %+v", merged)
+	}
+	if contains, _ := db.ContainsPost(testPostNA.Id); contains {
+		t.Fatal("merged post still exists")
+	}
+	byMessage, err := db.GetPostByMessage(1000, 12)
+	if err != nil || byMessage.Id != testPost1111.Id {
+		t.Fatalf("message of the merged post isn't mapped to the post: %v", err)
+	}
+	similar, err := db.FindSimilarMedia(0xff, 0, "")
+	if err != nil || len(similar) != 1 || similar[0].PostId != testPost1111.Id || similar[0].Index != 2 {
+		t.Fatalf("hash hasn't followed the file: %+v, %v", similar, err)
+	}
+
+	reordered, err := db.ReorderPost(testPost1111.Id, []int{2, 0, 1, 3}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Join([]string{reordered.Files[0].Id, reordered.Files[1].Id}, ", ") != "kitty photo 2222, kitty photo 1111" ||
+		reordered.OriginalMsgIds[0] != 12 {
+		t.Fatalf("reordered post is wrong: %+v", reordered)
+	}
+	_, err = db.ReorderPost(testPost1111.Id, []int{0, 0, 1, 2}, nil)
+	if err == nil {
+		t.Fatal("repeated items are expected to fail")
+	}
+
+	dropped, err := db.DropPostItem(testPost1111.Id, 3, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(dropped.Files) != 3 {
+		t.Fatalf("dropped post is wrong: %+v", dropped)
+	}
+	if _, err = db.GetPostByMessage(1000, 13); !IsErrRedisNotFound(err) {
+		t.Fatal("message of the dropped item is still mapped")
+	}
+
+	// the new post can't be put, since its key is taken by a string
+	err = db.client.Set(redisContext, db.toKey("post", "1000_7", "files"), "taken", 0).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _, err = db.SplitPost(testPost1111.Id, 1, nil)
+	if err == nil {
+		t.Fatal("split into the taken key is expected to fail")
+	}
+	restored, err := db.GetPost(testPost1111.Id)
+	if err != nil || len(restored.Files) != 3 || restored.Text != testPost1111.Text {
+		t.Fatalf("post isn't restored after the failed split: %+v, %v", restored, err)
+	}
+	if contains, _ := db.ContainsPost("1000_7"); contains {
+		t.Fatal("partially put post is left")
+	}
+	byMessage, err = db.GetPostByMessage(1000, 8)
+	if err != nil || byMessage.Id != testPost1111.Id {
+		t.Fatalf("message of the item isn't mapped back to the post: %v", err)
+	}
+
+	first, second, err := db.SplitPost(testPost1111.Id, 1, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(first.Files) != 1 || len(second.Files) != 2 || second.Id != "1000_7" || second.Text != "" {
+		t.Fatalf("split posts are wrong: %+v, %+v", first, second)
+	}
+	byMessage, err = db.GetPostByMessage(1000, 8)
+	if err != nil || byMessage.Id != second.Id {
+		t.Fatalf("message of the split item isn't mapped to the new post: %v", err)
+	}
+}
+
+func TestDatabase_RearrangePostsCheck(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the text fits the sticker post, but not the caption, which it'd become after the merge
+	sticker := &PostInfo{Id: "sticker", Time: TimeIsNotSpecified, Text: strings.Repeat("a", TelegramMaximumCaptionLength+1),
+		Files: []TgFileInfo{{Type: TelegramFileTypeSticker, Id: "sticker"}}, AdminPostedId: 1000, OriginalMsgIds: []int64{1}}
+	photo := &PostInfo{Id: "photo", Time: TimeIsNotSpecified,
+		Files: []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "photo"}}, AdminPostedId: 1000, OriginalMsgIds: []int64{2}}
+	for _, post := range []*PostInfo{sticker, photo} {
+		_, err = db.AddPost(post)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	joi := &Joi{}
+	_, err = db.MergePosts(sticker.Id, photo.Id, joi.checkPost)
+	if err == nil {
+		t.Fatal("merge making the text longer, than the caption allows, isn't rejected")
+	}
+	for _, post := range []*PostInfo{sticker, photo} {
+		kept, err := db.GetPost(post.Id)
+		if err != nil || len(kept.Files) != 1 {
+			t.Fatalf("post %s is changed by the rejected merge: %+v, %v", post.Id, kept, err)
+		}
+	}
+}
+
+func TestDatabase_MigrateFormatting(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = db.AddPost(&testPost1111)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// the way posts were stored before entities
+	err = db.client.Del(redisContext, db.toKey("post", testPost1111.Id, "text_entities"), db.toKey("post", testPost1111.Id, "comment_entities")).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = db.client.Set(redisContext, db.toKey("post", testPost1111.Id, "text"), "*kitty* \\#1111", 0).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	migrated, err := db.MigrateFormatting(tele.ModeMarkdownV2)
+	if err != nil || migrated != 1 {
+		t.Fatalf("%d posts are migrated, %v", migrated, err)
+	}
+	post, err := db.GetPost(testPost1111.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if post.Text != "kitty #1111" || formatTestEntities(post.TextEntities) != "[bold 0 5]" || post.Comment != testPost1111.Comment {
+		t.Errorf("migrated post is %+v", post)
+	}
+
+	migrated, err = db.MigrateFormatting(tele.ModeMarkdownV2)
+	if err != nil || migrated != 0 {
+		t.Errorf("%d posts are migrated again, %v", migrated, err)
+	}
+}
+
+func TestDatabase_Tags(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tagged := testPost1111
+	tagged.Tags = []string{"art", "morning"}
+	_, err = db.AddPost(&tagged)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = db.AddPost(&testPostNA)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = db.GetRandomPostByTags(TimeIsNotSpecified, []string{"art"})
+	if !IsErrRedisNotFound(err) {
+		t.Fatalf("post for 11:11 is taken for the free slot, %v", err)
+	}
+	post, err := db.GetRandomPostByTags("11:11", []string{"anime", "morning"})
+	if err != nil || post.Id != tagged.Id || strings.Join(post.Tags, " ") != "art morning" {
+		t.Fatalf("tagged post isn't found: %+v, %v", post, err)
+	}
+
+	_, err = db.ChangePost(testPostNA.Id, ChangePostTags, []string{"anime"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	post, err = db.GetRandomPostByTags(TimeIsNotSpecified, []string{"anime"})
+	if err != nil || post.Id != testPostNA.Id {
+		t.Fatalf("retagged post isn't found: %+v, %v", post, err)
+	}
+
+	err = db.RemovePost(tagged.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	members, err := db.client.SMembers(redisContext, db.toKey("tag", "art")).Result()
+	if err != nil || len(members) != 0 {
+		t.Errorf("removed post is still tagged: %v, %v", members, err)
+	}
+}
+
+func TestDatabase_MediaHashes(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = db.AddPost(&testPost1111)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = db.AddPost(&testPostNA)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = db.AddMediaHash(testPost1111.Id, 0, 0xff00)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = db.AddMediaHash(testPostNA.Id, 1, 0xff01)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	similar, err := db.FindSimilarMedia(0xff00, 0, "")
+	if err != nil || len(similar) != 1 || similar[0].PostId != testPost1111.Id || similar[0].Index != 0 || similar[0].Link != "" {
+		t.Fatalf("exact match among queued is wrong: %+v, %v", similar, err)
+	}
+	similar, err = db.FindSimilarMedia(0xff00, 1, "")
+	if err != nil || len(similar) != 2 || similar[1].PostId != testPostNA.Id || similar[1].Distance != 1 {
+		t.Fatalf("similar queued are wrong: %+v, %v", similar, err)
+	}
+	similar, err = db.FindSimilarMedia(0xff00, 1, testPost1111.Id)
+	if err != nil || len(similar) != 1 || similar[0].PostId != testPostNA.Id {
+		t.Fatalf("the excluded post is found: %+v, %v", similar, err)
+	}
+
+	err = db.PublishMediaHashes(testPost1111.Id, "https://t.me/channel/42")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queued, err := db.client.HKeys(redisContext, db.toKey("hashes", "queued")).Result()
+	if err != nil || strings.Join(queued, " ") != testPostNA.Id+"/1" {
+		t.Fatalf("queued hashes after publishing are %v, %v", queued, err)
+	}
+	err = db.RemovePost(testPost1111.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	similar, err = db.FindSimilarMedia(0xff00, 0, "")
+	if err != nil || len(similar) != 1 || similar[0].PostId != testPost1111.Id || similar[0].Link != "https://t.me/channel/42" {
+		t.Fatalf("published hash isn't linked to the posted message: %+v, %v", similar, err)
+	}
+
+	err = db.RemovePost(testPostNA.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	similar, err = db.FindSimilarMedia(0xff01, 0, "")
+	if err != nil || len(similar) != 0 {
+		t.Errorf("hash of the removed queued post is found: %+v, %v", similar, err)
+	}
+}
@@ -0,0 +1,112 @@
+package joi
+
+import (
+	"context"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// checkDuplicates hashes photos of the freshly added post and looks for similar ones among queued and published posts,
+// if any is found, the admin is warned and the post is held until it's overridden with .force
+func (joi *Joi) checkDuplicates(post *PostInfo) {
+	maxDistance := DefaultDuplicateHashDistance
+	if joi.Cfg.DuplicateHashDistance != nil {
+		maxDistance = *joi.Cfg.DuplicateHashDistance
+	}
+	if maxDistance < 0 {
+		return
+	}
+
+	warnings := make([]string, len(post.Files))
+	jobs := make([]func(context.Context) error, 0)
+	for i, file := range post.Files {
+		if file.Type != TelegramFileTypePhoto && file.Type != TelegramFileTypeDocPhoto {
+			continue
+		}
+
+		i, file := i, file
+		jobs = append(jobs, func(ctx context.Context) error {
+			hash, err := joi.hashFile(ctx, file)
+			if err != nil {
+				log.Printf("while hashing %s of post %s, an error occured %s", file.Id, post.Id, err.Error())
+				return nil
+			}
+			similar, err := joi.Database.FindSimilarMedia(hash, maxDistance, post.Id)
+			if err != nil {
+				log.Printf("while looking for duplicates of %s, an error occured %s", file.Id, err.Error())
+				return nil
+			}
+			err = joi.Database.AddMediaHash(post.Id, i, hash)
+			if err != nil {
+				log.Printf("while saving hash of %s, an error occured %s", file.Id, err.Error())
+			}
+			if len(similar) > 0 {
+				warnings[i] = describeSimilarMedia(similar)
+			}
+			return nil
+		})
+	}
+	joi.pool.Run(jobs...)
+
+	held := false
+	for i, warning := range warnings {
+		if warning == "" {
+			continue
+		}
+		held = true
+		_, err := joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[i]), Chat: &tele.Chat{ID: post.AdminPostedId}},
+			fmt.Sprintf("looks like a duplicate of %s", warning), &tele.SendOptions{DisableWebPagePreview: true})
+		if err != nil {
+			log.Printf("while warning about duplicate %s, an error occured %s", post.Files[i].Id, err.Error())
+		}
+	}
+	if !held {
+		return
+	}
+
+	_, err := joi.Database.ChangePost(post.Id, ChangePostHeld, true)
+	if IsErrRedisNotFound(err) {
+		return // already removed
+	} else if err != nil {
+		log.Printf("while holding post %s, an error occured %s", post.Id, err.Error())
+		return
+	}
+	_, err = joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[0]), Chat: &tele.Chat{ID: post.AdminPostedId}},
+		"the post is held and won't be posted by the schedule, reply .force to post it anyway")
+	if err != nil {
+		log.Printf("while reporting held post %s, an error occured %s", post.Id, err.Error())
+	}
+}
+
+func (joi *Joi) hashFile(ctx context.Context, file TgFileInfo) (uint64, error) {
+	// not the same name as for conversions, since they run at the same time
+	localFileName := path.Join(joi.Cfg.TemporaryFilesDirectory, file.Id+".phash")
+	err := joi.downloadFile(ctx, file.Id, localFileName)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := os.Remove(localFileName)
+		if err != nil {
+			log.Printf("while removing %s, an error occured %s", localFileName, err.Error())
+		}
+	}()
+
+	return joi.Converter.ImageHash(ctx, localFileName)
+}
+
+func describeSimilarMedia(similar []SimilarMedia) string {
+	descriptions := make([]string, len(similar))
+	for i, media := range similar {
+		if media.Link != "" {
+			descriptions[i] = fmt.Sprintf("the post published at %s (distance %d)", media.Link, media.Distance)
+		} else {
+			descriptions[i] = fmt.Sprintf("item %d of the queued post /preview %s (distance %d)", media.Index+1, media.PostId, media.Distance)
+		}
+	}
+	return strings.Join(descriptions, ",\n")
+}
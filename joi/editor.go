@@ -0,0 +1,296 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	postEditorTime = iota
+	postEditorText
+	postEditorComment
+)
+
+const postEditorExcerptLength = 100
+
+// postEditorTimeout is how long the editor waits for the input, afterwards the admin's messages are handled as usual
+const postEditorTimeout = 10 * time.Minute
+
+// callbacks of the control message, the data of every button is the post id
+var (
+	editTimeButton      = &tele.Btn{Unique: "edit_time"}
+	editTextButton      = &tele.Btn{Unique: "edit_text"}
+	editCommentButton   = &tele.Btn{Unique: "edit_comment"}
+	editSourcesButton   = &tele.Btn{Unique: "edit_sources"}
+	editProtectedButton = &tele.Btn{Unique: "edit_protected"}
+	editPreviewButton   = &tele.Btn{Unique: "edit_preview"}
+	editPostButton      = &tele.Btn{Unique: "edit_post"}
+	editDeleteButton    = &tele.Btn{Unique: "edit_delete"}
+	editConfirmButton   = &tele.Btn{Unique: "edit_confirm_delete"}
+	editBackButton      = &tele.Btn{Unique: "edit_back"}
+)
+
+// postEditor waits for the admin's message with the new value of the post field, chosen on the control message
+type postEditor struct {
+	step    int
+	postId  string
+	control *tele.Message // refreshed once the value is changed
+	prompt  *tele.Message
+	expires time.Time
+}
+
+// waitsFor tells whether the message is the input, messages replying to anything but the prompt or the control message aren't
+func (editor *postEditor) waitsFor(msg *tele.Message) bool {
+	return isInputFor(msg, editor.prompt, editor.control)
+}
+
+// isInputFor tells whether the message replies to any of the messages, or to nothing at all
+func isInputFor(msg *tele.Message, messages ...*tele.Message) bool {
+	if msg.ReplyTo == nil {
+		return true
+	}
+	for _, message := range messages {
+		if message != nil && msg.ReplyTo.ID == message.ID {
+			return true
+		}
+	}
+	return false
+}
+
+type postEditors struct {
+	mutex   sync.Mutex
+	editors map[int64]*postEditor // admin id -> editor waiting for the input
+}
+
+// get returns the editor waiting for the admin's input, the expired one is forgotten
+func (editors *postEditors) get(adminId int64) *postEditor {
+	defer editors.mutex.Unlock()
+	editors.mutex.Lock()
+	editor := editors.editors[adminId]
+	if editor != nil && time.Now().After(editor.expires) {
+		delete(editors.editors, adminId)
+		return nil
+	}
+	return editor
+}
+
+func (editors *postEditors) set(adminId int64, editor *postEditor) {
+	defer editors.mutex.Unlock()
+	editors.mutex.Lock()
+	if editor == nil {
+		delete(editors.editors, adminId)
+	} else {
+		editors.editors[adminId] = editor
+	}
+}
+
+// handleEditor registers /edit and the buttons of the control message in the admin group
+func (joi *Joi) handleEditor(admin *tele.Group) {
+	admin.Handle("/edit", joi.startPostEditor)
+	admin.Handle(editTimeButton, joi.editorInput(postEditorTime, "send the time, i.e. 06:06, or NA to post it in a free slot (/cancel to stop)"))
+	admin.Handle(editTextButton, joi.editorInput(postEditorText, "send the new text of the post, or - to remove it (/cancel to stop)"))
+	admin.Handle(editCommentButton, joi.editorInput(postEditorComment, "send the new comment, or - to remove it (/cancel to stop)"))
+	admin.Handle(editSourcesButton, joi.editorAction(func(post *PostInfo) (*PostInfo, error) {
+		return joi.togglePostSources(post)
+	}))
+	admin.Handle(editProtectedButton, joi.editorAction(func(post *PostInfo) (*PostInfo, error) {
+		return joi.Database.ChangePost(post.Id, ChangePostIsProtected, !post.IsProtected)
+	}))
+	admin.Handle(editPreviewButton, func(ctx tele.Context) error {
+		post, err := joi.Database.GetPost(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		_ = ctx.Respond()
+		_, err = joi.worker.PostExtended(post, ctx.Chat().ID, &tele.SendOptions{ParseMode: joi.parseMode()}, false)
+		return err
+	})
+	admin.Handle(editPostButton, func(ctx tele.Context) error {
+		post, err := joi.Database.GetPost(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		_, err = joi.worker.Post(post)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+		}
+		_ = ctx.Respond()
+		return ctx.Edit(fmt.Sprintf("post %s is posted.", post.Id))
+	})
+	admin.Handle(editDeleteButton, func(ctx tele.Context) error {
+		_ = ctx.Respond()
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(menu.Row(
+			menu.Data("yes, delete it", editConfirmButton.Unique, ctx.Callback().Data),
+			menu.Data("no", editBackButton.Unique, ctx.Callback().Data),
+		))
+		return ctx.Edit(menu)
+	})
+	admin.Handle(editConfirmButton, func(ctx tele.Context) error {
+		err := joi.Database.RemovePost(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+		}
+		_ = ctx.Respond()
+		return ctx.Edit(fmt.Sprintf("post %s is removed.", ctx.Callback().Data))
+	})
+	admin.Handle(editBackButton, joi.editorAction(func(post *PostInfo) (*PostInfo, error) {
+		return post, nil
+	}))
+}
+
+// startPostEditor sends the control message of the linked post
+func (joi *Joi) startPostEditor(ctx tele.Context) error {
+	post, err := joi.extractLinkedPost(ctx)
+	if err != nil {
+		return err
+	}
+	return ctx.Reply(editorSummary(post), editorMarkup(post))
+}
+
+// editorAction applies the change to the post of the pressed button and refreshes the control message
+func (joi *Joi) editorAction(change func(post *PostInfo) (*PostInfo, error)) tele.HandlerFunc {
+	return func(ctx tele.Context) error {
+		post, err := joi.Database.GetPost(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		post, err = change(post)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+		}
+		_ = ctx.Respond()
+		err = ctx.Edit(editorSummary(post), editorMarkup(post))
+		if isNotModified(err) {
+			return nil
+		}
+		return err
+	}
+}
+
+// editorInput asks the admin for the value of the field, the next message of the admin goes to continuePostEditor,
+// unless it replies to some other message
+func (joi *Joi) editorInput(step int, prompt string) tele.HandlerFunc {
+	return func(ctx tele.Context) error {
+		_ = ctx.Respond()
+		promptMsg, err := joi.Bot.Send(ctx.Chat(), prompt)
+		if err != nil {
+			return err
+		}
+		joi.postEditors.set(ctx.Sender().ID, &postEditor{
+			step:    step,
+			postId:  ctx.Callback().Data,
+			control: ctx.Message(),
+			prompt:  promptMsg,
+			expires: time.Now().Add(postEditorTimeout),
+		})
+		return nil
+	}
+}
+
+func (joi *Joi) continuePostEditor(ctx tele.Context, editor *postEditor) error {
+	post, err := joi.Database.GetPost(editor.postId)
+	if err != nil {
+		joi.postEditors.set(ctx.Sender().ID, nil)
+		return err
+	}
+
+	text := FormattedText{Text: ctx.Message().Text, Entities: ctx.Message().Entities}
+	if strings.TrimSpace(text.Text) == "-" {
+		text = FormattedText{}
+	}
+	switch editor.step {
+	case postEditorTime:
+		postTime := strings.TrimSpace(ctx.Message().Text)
+		if postTime == "" || !isTimeValid(postTime) {
+			return ctx.Reply(fmt.Sprintf("time %s is invalid, send it again", postTime))
+		}
+		post, err = joi.Database.ChangePost(post.Id, ChangePostTime, strings.ToUpper(postTime))
+	case postEditorText:
+		post, err = joi.changePostText(post, ChangePostText, text)
+	case postEditorComment:
+		post, err = joi.changePostText(post, ChangePostComment, text)
+	}
+	if err != nil {
+		return ctx.Reply(err.Error() + ", send it again")
+	}
+	joi.postEditors.set(ctx.Sender().ID, nil)
+
+	_, err = joi.Bot.Edit(editor.control, editorSummary(post), editorMarkup(post))
+	if err != nil && !isNotModified(err) {
+		return err
+	}
+	joi.sendExpiring(time.Second*30, ctx.Chat(), "+", &tele.SendOptions{ReplyTo: ctx.Message()})
+	return nil
+}
+
+// cancelInput stops waiting for the value of the post field or cancels the poll wizard
+func (joi *Joi) cancelInput(ctx tele.Context) error {
+	if joi.postEditors.get(ctx.Sender().ID) != nil {
+		joi.postEditors.set(ctx.Sender().ID, nil)
+		return ctx.Reply("cancelled.")
+	}
+	return joi.cancelPollWizard(ctx)
+}
+
+func editorSummary(post *PostInfo) string {
+	lines := []string{
+		fmt.Sprintf("post %s, %d items", post.Id, len(post.Files)),
+		fmt.Sprintf("time: %s", post.Time),
+		fmt.Sprintf("text: \"%s\"", excerpt(post.Text, postEditorExcerptLength)),
+		fmt.Sprintf("comment: \"%s\"", excerpt(post.Comment, postEditorExcerptLength)),
+	}
+	if len(post.Tags) > 0 {
+		lines = append(lines, fmt.Sprintf("tags: %s", formatTags(post.Tags)))
+	}
+	if post.Held {
+		lines = append(lines, "held as a possible duplicate")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func editorMarkup(post *PostInfo) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(
+			menu.Data("🕒 time", editTimeButton.Unique, post.Id),
+			menu.Data("✏️ text", editTextButton.Unique, post.Id),
+			menu.Data("💬 comment", editCommentButton.Unique, post.Id),
+		),
+		menu.Row(
+			menu.Data(fmt.Sprintf("sources: %s", onOff(post.PostSources == PostSourcesTrue)), editSourcesButton.Unique, post.Id),
+			menu.Data(fmt.Sprintf("protected: %s", onOff(post.IsProtected)), editProtectedButton.Unique, post.Id),
+		),
+		menu.Row(
+			menu.Data("👁 preview", editPreviewButton.Unique, post.Id),
+			menu.Data("🚀 post now", editPostButton.Unique, post.Id),
+			menu.Data("🗑 delete", editDeleteButton.Unique, post.Id),
+		),
+	)
+	return menu
+}
+
+// isNotModified tells whether the control message is refreshed with the same content, i.e. nothing is changed
+func isNotModified(err error) bool {
+	return errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// excerpt cuts the text to the length (in runes), marking the cut with an ellipsis
+func excerpt(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
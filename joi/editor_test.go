@@ -0,0 +1,102 @@
+package joi
+
+import (
+	tele "gopkg.in/telebot.v3"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditorMarkup(t *testing.T) {
+	post := &PostInfo{Id: "1000_7", Time: "06:06", Text: strings.Repeat("я", 150), PostSources: PostSourcesTrue, Tags: []string{"art"}}
+
+	summary := editorSummary(post)
+	if !strings.Contains(summary, "text: \""+strings.Repeat("я", postEditorExcerptLength)+"…\"") || !strings.Contains(summary, "tags: #art") {
+		t.Errorf("summary is %s", summary)
+	}
+
+	buttons := map[string]string{}
+	for _, row := range editorMarkup(post).InlineKeyboard {
+		for _, button := range row {
+			buttons[button.Unique] = button.Text
+			if button.Data != post.Id {
+				t.Errorf("%s button carries %s instead of the post id", button.Unique, button.Data)
+			}
+		}
+	}
+	for _, endpoint := range []string{editTimeButton.Unique, editTextButton.Unique, editCommentButton.Unique, editSourcesButton.Unique,
+		editProtectedButton.Unique, editPreviewButton.Unique, editPostButton.Unique, editDeleteButton.Unique} {
+		if _, ok := buttons[endpoint]; !ok {
+			t.Errorf("there's no %s button", endpoint)
+		}
+	}
+	if buttons[editSourcesButton.Unique] != "sources: on" || buttons[editProtectedButton.Unique] != "protected: off" {
+		t.Errorf("toggles are %s and %s", buttons[editSourcesButton.Unique], buttons[editProtectedButton.Unique])
+	}
+}
+
+func TestJoi_continuePostEditor(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = db.AddPost(&testPost1111)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bot, _ := newTestBot(t)
+	joi := &Joi{Bot: bot, Database: db, postEditors: &postEditors{editors: map[int64]*postEditor{}},
+		pollWizards: &pollWizards{wizards: map[int64]*pollWizard{}}}
+	startEditor := func(step int) *postEditor {
+		editor := &postEditor{step: step, postId: testPost1111.Id, control: &tele.Message{ID: 3, Chat: &tele.Chat{ID: 1}},
+			prompt: &tele.Message{ID: 4}, expires: time.Now().Add(postEditorTimeout)}
+		joi.postEditors.set(1, editor)
+		return editor
+	}
+
+	for _, test := range []struct {
+		step    int
+		input   string
+		waiting bool
+		check   func(post *PostInfo) bool
+	}{
+		{postEditorTime, "25:61", true, func(post *PostInfo) bool { return post.Time == testPost1111.Time }},
+		{postEditorTime, "na", false, func(post *PostInfo) bool { return post.Time == TimeIsNotSpecified }},
+		{postEditorText, "new text", false, func(post *PostInfo) bool { return post.Text == "new text" }},
+		{postEditorComment, " - ", false, func(post *PostInfo) bool { return post.Comment == "" }},
+	} {
+		editor := startEditor(test.step)
+		err = joi.continuePostEditor(testAdminMessage(bot, test.input), editor)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if waiting := joi.postEditors.get(1) != nil; waiting != test.waiting {
+			t.Errorf("after '%s' the editor is waiting=%t, expected %t", test.input, waiting, test.waiting)
+		}
+		post, err := db.GetPost(testPost1111.Id)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !test.check(post) {
+			t.Errorf("after '%s' the post is %+v", test.input, post)
+		}
+	}
+
+	startEditor(postEditorText)
+	err = joi.cancelInput(testAdminMessage(bot, "/cancel"))
+	if err != nil || joi.postEditors.get(1) != nil {
+		t.Errorf("the editor isn't cancelled, %v", err)
+	}
+
+	editor := startEditor(postEditorText)
+	for replyTo, waits := range map[int]bool{3: true, 4: true, 5: false} {
+		if editor.waitsFor(&tele.Message{ReplyTo: &tele.Message{ID: replyTo}}) != waits {
+			t.Errorf("the reply to message %d is expected to be the input=%t", replyTo, waits)
+		}
+	}
+	editor.expires = time.Now().Add(-time.Second)
+	if joi.postEditors.get(1) != nil {
+		t.Errorf("the expired editor is still waiting")
+	}
+}
@@ -0,0 +1,412 @@
+package joi
+
+import (
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// utf16Length returns length of the text in UTF-16 code units, in which Telegram measures entities
+func utf16Length(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// sliceText cuts the text between from and to (in UTF-16 code units), entities are clipped and shifted accordingly
+func sliceText(text string, entities tele.Entities, from int, to int) (string, tele.Entities) {
+	encoded := utf16.Encode([]rune(text))
+	if to > len(encoded) {
+		to = len(encoded)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from >= to {
+		return "", nil
+	}
+
+	sliced := make(tele.Entities, 0, len(entities))
+	for _, entity := range entities {
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+		if start < end {
+			entity.Offset = start - from
+			entity.Length = end - start
+			sliced = append(sliced, entity)
+		}
+	}
+
+	return string(utf16.Decode(encoded[from:to])), sliced
+}
+
+// commandPayload cuts the command and the whitespaces after it from the message text, keeping the entities of the rest,
+// the payload starts where the bot_command entity ends, since the text before the payload might contain it too
+func commandPayload(text string, entities tele.Entities) (string, tele.Entities) {
+	for _, entity := range entities {
+		if entity.Type != tele.EntityCommand || entity.Offset != 0 {
+			continue
+		}
+		rest, _ := sliceText(text, nil, entity.Offset+entity.Length, utf16Length(text))
+		from := entity.Offset + entity.Length + utf16Length(rest) - utf16Length(strings.TrimLeftFunc(rest, unicode.IsSpace))
+		return sliceText(text, entities, from, utf16Length(text))
+	}
+	return "", nil
+}
+
+// trimText cuts leading and trailing whitespaces of the text, keeping entities in place
+func trimText(text string, entities tele.Entities) (string, tele.Entities) {
+	from := utf16Length(text) - utf16Length(strings.TrimLeftFunc(text, unicode.IsSpace))
+	to := utf16Length(strings.TrimRightFunc(text, unicode.IsSpace))
+	return sliceText(text, entities, from, to)
+}
+
+// splitCaption splits the caption at the line consisting of the marker only into the text and the comment,
+// without the marker the whole caption is the text
+func splitCaption(caption string, entities tele.Entities, marker string) (text string, textEntities tele.Entities, comment string, commentEntities tele.Entities) {
+	offset := 0
+	for _, line := range strings.SplitAfter(caption, "\n") {
+		if marker != "" && strings.TrimSpace(line) == marker {
+			markerStart, markerEnd := utf16Length(caption[:offset]), utf16Length(caption[:offset+len(line)])
+			text, textEntities = trimText(sliceText(caption, entities, 0, markerStart))
+			comment, commentEntities = trimText(sliceText(caption, entities, markerEnd, utf16Length(caption)))
+			return
+		}
+		offset += len(line)
+	}
+	text, textEntities = trimText(caption, entities)
+	return
+}
+
+// markupEntity is an entity of the message with its markers in the parse mode, bounds are in UTF-16 code units
+type markupEntity struct {
+	start int
+	end   int
+	open  string
+	close string
+	code  bool // other entities can't be nested inside
+}
+
+// markup describes how the parse mode marks entities up
+type markup struct {
+	markers func(entity tele.MessageEntity) (open string, close string, code bool, supported bool)
+	escape  func(text string, inside []markupEntity) string // inside are the opened entities, the innermost is the last
+	nested  bool                                            // otherwise entities inside the others are dropped
+	// separator is put between adjacent markers, if they are ambiguous together
+	separator func(previous string, next string) string
+}
+
+var markdownV2Markup = markup{
+	markers: func(entity tele.MessageEntity) (string, string, bool, bool) {
+		switch entity.Type {
+		case tele.EntityBold:
+			return "*", "*", false, true
+		case tele.EntityItalic:
+			return "_", "_", false, true
+		case tele.EntityUnderline:
+			return "__", "__", false, true
+		case tele.EntityStrikethrough:
+			return "~", "~", false, true
+		case tele.EntitySpoiler:
+			return "||", "||", false, true
+		case tele.EntityCode:
+			return "`", "`", true, true
+		case tele.EntityCodeBlock:
+			return "```" + entity.Language + "\n", "\n```", true, true
+		case tele.EntityTextLink:
+			return "[", fmt.Sprintf("](%s)", escapeTgMarkdownV2Link(entity.URL)), false, true
+		case tele.EntityTMention:
+			if entity.User == nil {
+				return "", "", false, false
+			}
+			return "[", fmt.Sprintf("](tg://user?id=%d)", entity.User.ID), false, true
+		case tele.EntityCustomEmoji:
+			return "![", fmt.Sprintf("](%s%s)", tgEmojiLinkPrefix, entity.CustomEmoji), false, true
+		case tele.EntityBlockquote:
+			return ">", "", false, true
+		case EntityExpandableBlockquote:
+			return "**>", "||", false, true
+		}
+		return "", "", false, false
+	},
+	escape: func(text string, inside []markupEntity) string {
+		quoted := false
+		for _, entity := range inside {
+			if entity.code {
+				return escapeTgMarkdownV2Code(text)
+			}
+			quoted = quoted || strings.HasSuffix(entity.open, ">")
+		}
+		text = escapeTgMarkdownV2SpecialSymbols(text)
+		if quoted {
+			// every line of the quote starts with '>'
+			text = strings.ReplaceAll(text, "\n", "\n>")
+		}
+		return text
+	},
+	nested: true,
+	separator: func(previous string, next string) string {
+		// '_' of italic next to '__' of underline is ambiguous, '\r' in between is ignored by Telegram
+		if strings.HasSuffix(previous, "_") && strings.HasPrefix(next, "_") {
+			return "\r"
+		}
+		return ""
+	},
+}
+
+// legacyMarkdownMarkup can't express underline, strikethrough, spoiler, quotes and custom emoji, which are dropped,
+// and nested entities
+var legacyMarkdownMarkup = markup{
+	markers: func(entity tele.MessageEntity) (string, string, bool, bool) {
+		switch entity.Type {
+		case tele.EntityBold:
+			return "*", "*", false, true
+		case tele.EntityItalic:
+			return "_", "_", false, true
+		case tele.EntityCode:
+			return "`", "`", true, true
+		case tele.EntityCodeBlock:
+			return "```" + entity.Language + "\n", "\n```", true, true
+		case tele.EntityTextLink:
+			// urls can't be escaped there
+			return "[", fmt.Sprintf("](%s)", strings.ReplaceAll(entity.URL, ")", "%29")), false, true
+		case tele.EntityTMention:
+			if entity.User == nil {
+				return "", "", false, false
+			}
+			return "[", fmt.Sprintf("](tg://user?id=%d)", entity.User.ID), false, true
+		}
+		return "", "", false, false
+	},
+	escape: func(text string, inside []markupEntity) string {
+		if len(inside) == 0 {
+			return strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[").Replace(text)
+		}
+		// there's no escaping inside of entities, the entity is closed around the escaped symbol instead
+		entity := inside[0]
+		symbol := strings.TrimLeft(entity.close, "\n")[:1]
+		return strings.ReplaceAll(text, symbol, entity.close+"\\"+symbol+entity.open)
+	},
+	nested: false,
+}
+
+var htmlMarkup = markup{
+	markers: func(entity tele.MessageEntity) (string, string, bool, bool) {
+		switch entity.Type {
+		case tele.EntityBold:
+			return "<b>", "</b>", false, true
+		case tele.EntityItalic:
+			return "<i>", "</i>", false, true
+		case tele.EntityUnderline:
+			return "<u>", "</u>", false, true
+		case tele.EntityStrikethrough:
+			return "<s>", "</s>", false, true
+		case tele.EntitySpoiler:
+			return "<tg-spoiler>", "</tg-spoiler>", false, true
+		case tele.EntityCode:
+			return "<code>", "</code>", true, true
+		case tele.EntityCodeBlock:
+			if entity.Language == "" {
+				return "<pre>", "</pre>", true, true
+			}
+			return fmt.Sprintf("<pre><code class=\"language-%s\">", html.EscapeString(entity.Language)), "</code></pre>", true, true
+		case tele.EntityTextLink:
+			return fmt.Sprintf("<a href=\"%s\">", html.EscapeString(entity.URL)), "</a>", false, true
+		case tele.EntityTMention:
+			if entity.User == nil {
+				return "", "", false, false
+			}
+			return fmt.Sprintf("<a href=\"tg://user?id=%d\">", entity.User.ID), "</a>", false, true
+		case tele.EntityCustomEmoji:
+			return fmt.Sprintf("<tg-emoji emoji-id=\"%s\">", html.EscapeString(entity.CustomEmoji)), "</tg-emoji>", false, true
+		case tele.EntityBlockquote:
+			return "<blockquote>", "</blockquote>", false, true
+		case EntityExpandableBlockquote:
+			return "<blockquote expandable>", "</blockquote>", false, true
+		}
+		return "", "", false, false
+	},
+	escape: func(text string, _ []markupEntity) string {
+		return html.EscapeString(text)
+	},
+	nested: true,
+}
+
+// escapeTgMarkdownV2Link escapes the url of the inline link, only ')' and '\' are special there
+func escapeTgMarkdownV2Link(url string) string {
+	return strings.NewReplacer("\\", "\\\\", ")", "\\)").Replace(url)
+}
+
+// escapeTgMarkdownV2Code escapes the content of code and pre entities, only '`' and '\' are special there
+func escapeTgMarkdownV2Code(code string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(code)
+}
+
+// renderText marks the text up for the parse mode, without one (or with native entities) the text is left as is
+func renderText(text string, entities tele.Entities, parseMode string) string {
+	switch parseMode {
+	case tele.ModeMarkdownV2:
+		return renderEntities(text, entities, markdownV2Markup)
+	case tele.ModeMarkdown:
+		return renderEntities(text, entities, legacyMarkdownMarkup)
+	case tele.ModeHTML:
+		return renderEntities(text, entities, htmlMarkup)
+	default:
+		return text
+	}
+}
+
+// tgMessageToMarkdown converts the text with entities into MarkdownV2
+func tgMessageToMarkdown(text string, entities tele.Entities) string {
+	return renderEntities(text, entities, markdownV2Markup)
+}
+
+// renderEntities marks the text up. Entities overlapping each other are closed and reopened at the boundaries,
+// since markups require them to be properly nested.
+func renderEntities(text string, entities tele.Entities, markup markup) string {
+	encoded := utf16.Encode([]rune(text))
+
+	markupEntities := make([]markupEntity, 0, len(entities))
+	boundaries := []int{0, len(encoded)}
+	for _, entity := range entities {
+		open, close_, code, supported := markup.markers(entity)
+		if !supported {
+			if !isKnownEntity(entity.Type) {
+				log.Printf("entity of type %s is not supported", entity.Type)
+			}
+			continue
+		}
+		marked := markupEntity{start: entity.Offset, end: entity.Offset + entity.Length, open: open, close: close_, code: code}
+		if marked.start < 0 {
+			marked.start = 0
+		}
+		if marked.end > len(encoded) {
+			marked.end = len(encoded)
+		}
+		// the line after the quote isn't a part of it
+		isQuote := entity.Type == tele.EntityBlockquote || entity.Type == EntityExpandableBlockquote
+		if isQuote && marked.end > marked.start && encoded[marked.end-1] == '\n' {
+			marked.end--
+		}
+		if marked.start >= marked.end {
+			continue
+		}
+		markupEntities = append(markupEntities, marked)
+		boundaries = append(boundaries, marked.start, marked.end)
+	}
+	// the outer entity comes first among the ones starting at the same place
+	sort.SliceStable(markupEntities, func(i, j int) bool {
+		if markupEntities[i].start != markupEntities[j].start {
+			return markupEntities[i].start < markupEntities[j].start
+		}
+		return markupEntities[i].end > markupEntities[j].end
+	})
+	sort.Ints(boundaries)
+	unique := boundaries[:1]
+	for _, at := range boundaries[1:] {
+		if at != unique[len(unique)-1] {
+			unique = append(unique, at)
+		}
+	}
+	boundaries = unique
+
+	marked := strings.Builder{}
+	previousMarker := ""
+	writeMarker := func(marker string) {
+		if markup.separator != nil {
+			marked.WriteString(markup.separator(previousMarker, marker))
+		}
+		marked.WriteString(marker)
+		previousMarker = marker
+	}
+
+	opened := make([]markupEntity, 0, len(markupEntities))
+	next := 0
+	for i, at := range boundaries {
+		// the entities opened after the ending one are closed along with it and reopened
+		ending := len(opened)
+		for j, entity := range opened {
+			if entity.end <= at {
+				ending = j
+				break
+			}
+		}
+		reopened := make([]markupEntity, 0)
+		for len(opened) > ending {
+			entity := opened[len(opened)-1]
+			opened = opened[:len(opened)-1]
+			writeMarker(entity.close)
+			if entity.end > at {
+				reopened = append([]markupEntity{entity}, reopened...)
+			}
+		}
+		for ; next < len(markupEntities) && markupEntities[next].start <= at; next++ {
+			reopened = append(reopened, markupEntities[next])
+		}
+
+		insideCode := false
+		for _, entity := range opened {
+			insideCode = insideCode || entity.code
+		}
+		for _, entity := range reopened {
+			if insideCode || !markup.nested && len(opened) > 0 {
+				continue
+			}
+			writeMarker(entity.open)
+			opened = append(opened, entity)
+			insideCode = entity.code
+		}
+
+		if i+1 < len(boundaries) {
+			marked.WriteString(markup.escape(string(utf16.Decode(encoded[at:boundaries[i+1]])), opened))
+			previousMarker = ""
+		}
+	}
+
+	return marked.String()
+}
+
+// isKnownEntity tells whether Telegram sends entities of the type, the ones, which Telegram detects in the plain text
+// on its own (mentions, hashtags, urls, etc.), aren't marked up, but they are kept for native entities
+func isKnownEntity(entityType tele.EntityType) bool {
+	switch entityType {
+	case tele.EntityMention, tele.EntityURL, tele.EntityHashtag, tele.EntityCashtag, tele.EntityCommand, tele.EntityEmail, tele.EntityPhone,
+		tele.EntityBold, tele.EntityItalic, tele.EntityUnderline, tele.EntityStrikethrough, tele.EntitySpoiler, tele.EntityCode,
+		tele.EntityCodeBlock, tele.EntityTextLink, tele.EntityTMention, tele.EntityCustomEmoji, tele.EntityBlockquote, EntityExpandableBlockquote:
+		return true
+	}
+	return false
+}
+
+// parseMode is the one texts are sent with, none if entities are sent natively
+func (joi *Joi) parseMode() string {
+	if joi.Cfg.ParseMode == ModeEntities {
+		return tele.ModeDefault
+	}
+	return joi.Cfg.ParseMode
+}
+
+// render marks the text up for the parse mode of the bot, it's sent with options given by formatOptions
+func (joi *Joi) render(text string, entities tele.Entities) string {
+	return renderText(text, entities, joi.Cfg.ParseMode)
+}
+
+// formatOptions returns a copy of the options to send the rendered text with, entities are attached,
+// if they are sent natively
+func (joi *Joi) formatOptions(opts *tele.SendOptions, entities tele.Entities) *tele.SendOptions {
+	formatted := *opts
+	formatted.ParseMode = joi.parseMode()
+	formatted.Entities = nil
+	if joi.Cfg.ParseMode == ModeEntities {
+		formatted.Entities = entities
+	}
+	return &formatted
+}
@@ -0,0 +1,125 @@
+package joi
+
+import (
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"testing"
+)
+
+func TestSplitCaption(t *testing.T) {
+	for _, test := range []struct {
+		caption         string
+		entities        tele.Entities
+		text            string
+		textEntities    string
+		comment         string
+		commentEntities string
+	}{
+		{caption: "just a text", text: "just a text", textEntities: "[]"},
+		{caption: "text\n---\ncomment", text: "text", textEntities: "[]", comment: "comment", commentEntities: "[]"},
+		{caption: "---\n only comment ", text: "", textEntities: "[]", comment: "only comment", commentEntities: "[]"},
+		{caption: "not a --- marker", text: "not a --- marker", textEntities: "[]"},
+		{
+			caption:         "🔥 bold\n ---\n🔥 italic",
+			entities:        tele.Entities{{Type: tele.EntityBold, Offset: 3, Length: 4}, {Type: tele.EntityItalic, Offset: 16, Length: 6}},
+			text:            "🔥 bold",
+			textEntities:    "[bold 3 4]",
+			comment:         "🔥 italic",
+			commentEntities: "[italic 3 6]",
+		},
+	} {
+		text, textEntities, comment, commentEntities := splitCaption(test.caption, test.entities, "---")
+		if text != test.text || comment != test.comment {
+			t.Errorf("'%s' is split into '%s' and '%s', expected '%s' and '%s'", test.caption, text, comment, test.text, test.comment)
+		}
+		if test.textEntities != "" && formatTestEntities(textEntities) != test.textEntities {
+			t.Errorf("entities of the text of '%s' are %s, expected %s", test.caption, formatTestEntities(textEntities), test.textEntities)
+		}
+		if test.commentEntities != "" && formatTestEntities(commentEntities) != test.commentEntities {
+			t.Errorf("entities of the comment of '%s' are %s, expected %s", test.caption, formatTestEntities(commentEntities), test.commentEntities)
+		}
+	}
+}
+
+func TestCommandPayload(t *testing.T) {
+	command := func(length int) tele.MessageEntity {
+		return tele.MessageEntity{Type: tele.EntityCommand, Offset: 0, Length: length}
+	}
+	for _, test := range []struct {
+		text     string
+		entities tele.Entities
+		payload  string
+		expected string
+	}{
+		{text: "/text hello", entities: tele.Entities{command(5)}, payload: "hello", expected: "[]"},
+		{text: "/text@joi_bot \n 🔥 bold", entities: tele.Entities{command(13), {Type: tele.EntityBold, Offset: 19, Length: 4}},
+			payload: "🔥 bold", expected: "[bold 3 4]"},
+		// the payload is repeated in the command's text, so searching for it finds the wrong place
+		{text: "/text t", entities: tele.Entities{command(5)}, payload: "t", expected: "[]"},
+		{text: "/text", entities: tele.Entities{command(5)}, payload: "", expected: "[]"},
+		{text: "no command", payload: "", expected: "[]"},
+	} {
+		payload, entities := commandPayload(test.text, test.entities)
+		if payload != test.payload || formatTestEntities(entities) != test.expected {
+			t.Errorf("payload of '%s' is '%s' %s, expected '%s' %s", test.text, payload, formatTestEntities(entities), test.payload, test.expected)
+		}
+	}
+}
+
+func TestTgMessageToMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		entities tele.Entities
+		expected string
+	}{
+		{"plain text.", nil, "plain text\\."},
+		{"back\\slash", nil, "back\\\\slash"},
+		{"🔥 bold", tele.Entities{{Type: tele.EntityBold, Offset: 3, Length: 4}}, "🔥 *bold*"},
+		{"привет, мир", tele.Entities{{Type: tele.EntityItalic, Offset: 8, Length: 3}}, "привет, _мир_"},
+		{"日本語のテキスト", tele.Entities{{Type: tele.EntityUnderline, Offset: 0, Length: 3}}, "__日本語__のテキスト"},
+		// 𝄞 and 😀 are surrogate pairs, two code units each
+		{"𝄞😀 end", tele.Entities{{Type: tele.EntityStrikethrough, Offset: 2, Length: 2}, {Type: tele.EntitySpoiler, Offset: 5, Length: 3}}, "𝄞~😀~ ||end||"},
+		{
+			"👨‍👩‍👧 link",
+			tele.Entities{{Type: tele.EntityTextLink, Offset: 0, Length: 13, URL: "https://example.com/a_(b)"}},
+			"[👨‍👩‍👧 link](https://example.com/a_(b\\))",
+		},
+		{
+			"bold inside link",
+			tele.Entities{{Type: tele.EntityTextLink, Offset: 0, Length: 16, URL: "https://example.com"}, {Type: tele.EntityBold, Offset: 0, Length: 4}},
+			"[*bold* inside link](https://example.com)",
+		},
+		{
+			"overlapping entities",
+			tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 11}, {Type: tele.EntityItalic, Offset: 5, Length: 15}},
+			"*overl_apping_*_ entities_",
+		},
+		{
+			"all at once",
+			tele.Entities{{Type: tele.EntityItalic, Offset: 0, Length: 11}, {Type: tele.EntityUnderline, Offset: 0, Length: 11}},
+			"_\r__all at once__\r_",
+		},
+		{"x = a*b", tele.Entities{{Type: tele.EntityCode, Offset: 4, Length: 3}, {Type: tele.EntityBold, Offset: 5, Length: 1}}, "x \\= `a*b`"},
+		{"go:\nfmt.Println(`hi`)", tele.Entities{{Type: tele.EntityCodeBlock, Offset: 4, Length: 17, Language: "go"}}, "go:\n```go\nfmt.Println(\\`hi\\`)\n```"},
+		{"🔥 @user #tag", tele.Entities{{Type: tele.EntityMention, Offset: 3, Length: 5}, {Type: tele.EntityHashtag, Offset: 9, Length: 4}}, "🔥 @user \\#tag"},
+		{"mention", tele.Entities{{Type: tele.EntityTMention, Offset: 0, Length: 7, User: &tele.User{ID: 42}}}, "[mention](tg://user?id=42)"},
+		{"out of range", tele.Entities{{Type: tele.EntityBold, Offset: 7, Length: 100}}, "out of *range*"},
+		{"👍 ok", tele.Entities{{Type: tele.EntityCustomEmoji, Offset: 0, Length: 2, CustomEmoji: "5368324170671202286"}}, "![👍](tg://emoji?id=5368324170671202286) ok"},
+		{"said:\nfirst\nsecond\nafter", tele.Entities{{Type: tele.EntityBlockquote, Offset: 6, Length: 13}}, "said:\n>first\n>second\nafter"},
+		{"long\nquote", tele.Entities{{Type: EntityExpandableBlockquote, Offset: 0, Length: 10}, {Type: tele.EntityUnderline, Offset: 5, Length: 5}}, "**>long\n>__quote__||"},
+		{"$TON #tag", tele.Entities{{Type: tele.EntityCashtag, Offset: 0, Length: 4}, {Type: tele.EntityHashtag, Offset: 5, Length: 4}}, "$TON \\#tag"},
+	} {
+		markdown := tgMessageToMarkdown(test.text, test.entities)
+		if markdown != test.expected {
+			t.Errorf("'%s' is converted into '%s', expected '%s'", test.text, markdown, test.expected)
+		}
+	}
+}
+
+func formatTestEntities(entities tele.Entities) string {
+	formatted := make([]string, len(entities))
+	for i, entity := range entities {
+		formatted[i] = fmt.Sprintf("%s %d %d", entity.Type, entity.Offset, entity.Length)
+	}
+	return fmt.Sprint(formatted)
+}
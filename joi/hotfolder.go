@@ -0,0 +1,321 @@
+package joi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultHotFolderPolling = 30 * time.Second
+
+// HotFolderWatcher turns files dropped into the directory into posts, every file is a post on its own,
+// every sub-folder is an album. Sidecar .txt (text, the comment after the caption marker)
+// or .json (see hotFolderSidecar) files with the same name supply text, comment and time.
+// Processed files are moved to the archive.
+type HotFolderWatcher struct {
+	Joi            *Joi
+	Directory      string
+	Archive        string
+	PollingTimeout time.Duration
+	OnError        func(error)
+
+	failed map[string]time.Time // entry -> modification time, when it has failed, so it's not retried until changed
+	stop   chan struct{}
+}
+
+type hotFolderEntry struct {
+	Path     string
+	Files    []string // to be uploaded, sorted by name
+	Sidecars []string
+	Modified time.Time // the latest modification time among the files and sidecars
+}
+
+type hotFolderSidecar struct {
+	Text    *string `json:"text"`    // in the parse mode of the bot
+	Comment *string `json:"comment"` // in the parse mode of the bot
+	Time    string  `json:"time"`
+}
+
+func NewHotFolderWatcher(joi *Joi, directory string, archive string, period ...time.Duration) *HotFolderWatcher {
+	period_ := DefaultHotFolderPolling
+	if len(period) > 0 {
+		period_ = period[0]
+	}
+
+	return &HotFolderWatcher{
+		Joi:            joi,
+		Directory:      directory,
+		Archive:        archive,
+		PollingTimeout: period_,
+		OnError:        func(error) {},
+		failed:         map[string]time.Time{},
+		stop:           make(chan struct{}),
+	}
+}
+
+func (watcher *HotFolderWatcher) Start() {
+	ticker := time.NewTicker(watcher.PollingTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case tick := <-ticker.C:
+			// files modified during the last period might be still being copied
+			err := watcher.Scan(tick.Add(-watcher.PollingTimeout))
+			if err != nil {
+				watcher.OnError(err)
+			}
+		}
+	}
+}
+
+func (watcher *HotFolderWatcher) Stop() {
+	close(watcher.stop)
+}
+
+// Scan adds posts from the entries, which haven't been modified after settledBefore
+func (watcher *HotFolderWatcher) Scan(settledBefore time.Time) error {
+	entries, err := hotFolderEntries(watcher.Directory, watcher.Archive)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Modified.After(settledBefore) {
+			continue
+		}
+		if failedAt, failed := watcher.failed[entry.Path]; failed && !entry.Modified.After(failedAt) {
+			continue
+		}
+
+		err = watcher.ingest(entry)
+		if err != nil {
+			watcher.failed[entry.Path] = entry.Modified
+			watcher.OnError(errors.New(fmt.Sprintf("hot folder: couldn't add %s, %s", filepath.Base(entry.Path), err.Error())))
+			continue
+		}
+		delete(watcher.failed, entry.Path)
+
+		err = watcher.archive(entry)
+		if err != nil {
+			watcher.OnError(err)
+		}
+	}
+	return nil
+}
+
+// ingest uploads files of the entry to the storage chat (or to the first admin), to get their file ids,
+// and adds the post from the uploaded messages
+func (watcher *HotFolderWatcher) ingest(entry hotFolderEntry) error {
+	joi := watcher.Joi
+
+	base := &PostInfo{
+		Text:         joi.defaultPostText.Text,
+		TextEntities: joi.defaultPostText.Entities,
+		Buttons:      joi.Cfg.DefaultButtons,
+	}
+	err := readHotFolderSidecars(base, entry.Sidecars, joi.Cfg.CaptionCommentMarker, joi.Cfg.ParseMode)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range entry.Files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.Size() > TelegramMaximumFileSizeAllowed {
+			return errors.New(fmt.Sprintf("%s of %.2fMB is too big, maximum Telegram allows - %dMB",
+				filepath.Base(file), float64(info.Size())/megabyte, TelegramMaximumFileSizeAllowed/megabyte))
+		}
+	}
+
+	storage := &tele.Chat{ID: joi.Cfg.StorageChatId}
+	if joi.Cfg.StorageChatId == 0 {
+		if len(joi.Cfg.AdminList) == 0 {
+			return errors.New("neither storage chat nor admins are specified")
+		}
+		storage.ID = joi.Cfg.AdminList[0]
+	}
+	base.AdminPostedId = storage.ID
+
+	// sent as documents, so they go through the same conversion as the ones sent by admins
+	documents := make([]tele.Sendable, len(entry.Files))
+	for i, file := range entry.Files {
+		documents[i] = &tele.Document{File: tele.FromDisk(file), FileName: filepath.Base(file)}
+	}
+	sent, err := joi.worker.sendMedia(storage, splitMedia(documents, TelegramMaximumAlbumSize), &tele.SendOptions{DisableNotification: true})
+	if err != nil {
+		return err
+	}
+	messages := make([]*tele.Message, 0, len(entry.Files))
+	for _, album := range sent {
+		for i := range album {
+			messages = append(messages, &album[i])
+		}
+	}
+
+	post, err := joi.addPost(base, messages...)
+	if err != nil {
+		return err
+	}
+	go joi.preconvertPost(post)
+	go joi.checkDuplicates(post)
+
+	return nil
+}
+
+// archive moves the entry with its sidecars to the archive, names are suffixed with the time, if already taken
+func (watcher *HotFolderWatcher) archive(entry hotFolderEntry) error {
+	paths := []string{entry.Path}
+	for _, sidecar := range entry.Sidecars {
+		if filepath.Clean(filepath.Dir(sidecar)) == filepath.Clean(watcher.Directory) {
+			paths = append(paths, sidecar) // the ones inside sub-folders are moved with them
+		}
+	}
+
+	for _, from := range paths {
+		to := filepath.Join(watcher.Archive, filepath.Base(from))
+		if _, err := os.Stat(to); err == nil {
+			to = fmt.Sprintf("%s_%s", to, time.Now().Format("20060102150405"))
+		}
+		err := os.Rename(from, to)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hotFolderEntries lists files and sub-folders of the directory with their sidecars,
+// hidden files, the archive and sidecars without an entry are skipped
+func hotFolderEntries(directory string, archive string) ([]hotFolderEntry, error) {
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, dirEntry := range dirEntries {
+		names[dirEntry.Name()] = true
+	}
+
+	entries := make([]hotFolderEntry, 0)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		entryPath := filepath.Join(directory, name)
+		if strings.HasPrefix(name, ".") || isHotFolderSidecar(name) || filepath.Clean(entryPath) == filepath.Clean(archive) {
+			continue
+		}
+
+		entry := hotFolderEntry{Path: entryPath}
+		if dirEntry.IsDir() {
+			files, err := os.ReadDir(entryPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+					continue
+				}
+				if isHotFolderSidecar(file.Name()) {
+					entry.Sidecars = append(entry.Sidecars, filepath.Join(entryPath, file.Name()))
+				} else {
+					entry.Files = append(entry.Files, filepath.Join(entryPath, file.Name()))
+				}
+			}
+			if len(entry.Files) == 0 {
+				continue
+			}
+			sort.Strings(entry.Files)
+		} else {
+			entry.Files = []string{entryPath}
+		}
+
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if dirEntry.IsDir() {
+			stem = name
+		}
+		for _, extension := range []string{".txt", ".json"} {
+			if names[stem+extension] {
+				entry.Sidecars = append(entry.Sidecars, filepath.Join(directory, stem+extension))
+			}
+		}
+		// values of .json override the ones of .txt
+		sort.SliceStable(entry.Sidecars, func(i, j int) bool {
+			return strings.ToLower(filepath.Ext(entry.Sidecars[i])) == ".txt" && strings.ToLower(filepath.Ext(entry.Sidecars[j])) != ".txt"
+		})
+
+		for _, file := range append(append([]string{}, entry.Files...), entry.Sidecars...) {
+			info, err := os.Stat(file)
+			if err != nil {
+				return nil, err
+			}
+			if info.ModTime().After(entry.Modified) {
+				entry.Modified = info.ModTime()
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func isHotFolderSidecar(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return extension == ".txt" || extension == ".json"
+}
+
+// readHotFolderSidecars fills text, comment and time of the post, .txt is plain text,
+// split into the text and the comment by the marker, .json values are in the parse mode
+func readHotFolderSidecars(base *PostInfo, sidecars []string, marker string, parseMode string) error {
+	for _, sidecar := range sidecars {
+		content, err := os.ReadFile(sidecar)
+		if err != nil {
+			return err
+		}
+
+		if strings.ToLower(filepath.Ext(sidecar)) == ".txt" {
+			text, textEntities, comment, commentEntities := splitCaption(string(content), nil, marker)
+			if text != "" {
+				base.Text, base.TextEntities = text, textEntities
+			}
+			base.Comment, base.CommentEntities = comment, commentEntities
+			continue
+		}
+
+		var values hotFolderSidecar
+		err = json.Unmarshal(content, &values)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s\nfor sidecar %s", err.Error(), filepath.Base(sidecar)))
+		}
+		if values.Text != nil {
+			text, err := parseMarkup(*values.Text, parseMode)
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s\nfor text of sidecar %s", err.Error(), filepath.Base(sidecar)))
+			}
+			base.Text, base.TextEntities = text.Text, text.Entities
+		}
+		if values.Comment != nil {
+			comment, err := parseMarkup(*values.Comment, parseMode)
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s\nfor comment of sidecar %s", err.Error(), filepath.Base(sidecar)))
+			}
+			base.Comment, base.CommentEntities = comment.Text, comment.Entities
+		}
+		if values.Time != "" {
+			if !isTimeValid(values.Time) {
+				return errors.New(fmt.Sprintf("time %s of sidecar %s is invalid", values.Time, filepath.Base(sidecar)))
+			}
+			base.Time = values.Time
+		}
+	}
+	return nil
+}
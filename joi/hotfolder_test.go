@@ -0,0 +1,87 @@
+package joi
+
+import (
+	tele "gopkg.in/telebot.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHotFolderEntries(t *testing.T) {
+	directory := t.TempDir()
+	archive := filepath.Join(directory, "archive")
+	for _, file := range []string{
+		"single.jpg", "single.txt", "orphan.json", ".hidden.png",
+		"album/2.png", "album/1.jpg", "album/post.json", "album.txt",
+		"empty/notes.txt", "archive/old.jpg",
+	} {
+		err := os.MkdirAll(filepath.Join(directory, filepath.Dir(file)), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(directory, file), []byte("content"), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := hotFolderEntries(directory, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative := func(paths []string) string {
+		names := make([]string, len(paths))
+		for i, path := range paths {
+			names[i], _ = filepath.Rel(directory, path)
+		}
+		return strings.Join(names, " ")
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("%d entries are found, expected 2: %+v", len(entries), entries)
+	}
+	if entries[0].Path != filepath.Join(directory, "album") || relative(entries[0].Files) != "album/1.jpg album/2.png" ||
+		relative(entries[0].Sidecars) != "album.txt album/post.json" {
+		t.Errorf("album entry is %+v", entries[0])
+	}
+	if entries[1].Path != filepath.Join(directory, "single.jpg") || relative(entries[1].Files) != "single.jpg" ||
+		relative(entries[1].Sidecars) != "single.txt" {
+		t.Errorf("single entry is %+v", entries[1])
+	}
+	if time.Since(entries[1].Modified) > time.Minute {
+		t.Errorf("modification time %s of the entry is wrong", entries[1].Modified)
+	}
+}
+
+func TestReadHotFolderSidecars(t *testing.T) {
+	directory := t.TempDir()
+	txt := filepath.Join(directory, "post.txt")
+	json := filepath.Join(directory, "post.json")
+	err := os.WriteFile(txt, []byte("Sunset.\n---\n#art"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(json, []byte(`{"comment": "*art* \\#sunset", "time": "12:12"}`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &PostInfo{Text: "default"}
+	err = readHotFolderSidecars(post, []string{txt, json}, "---", tele.ModeMarkdownV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Text != "Sunset." || post.Comment != "art #sunset" || formatTestEntities(post.CommentEntities) != "[bold 0 3]" || post.Time != "12:12" {
+		t.Errorf("post is %+v", *post)
+	}
+
+	err = os.WriteFile(json, []byte(`{"time": "25:00"}`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readHotFolderSidecars(post, []string{json}, "---", tele.ModeMarkdownV2) == nil {
+		t.Errorf("invalid time is expected to fail")
+	}
+}
@@ -0,0 +1,125 @@
+package joi
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ImageFormatJpeg = "jpeg"
+	ImageFormatPng  = "png"
+	ImageFormatGif  = "gif"
+	ImageFormatWebp = "webp"
+	ImageFormatHeic = "heic"
+	ImageFormatAvif = "avif"
+	ImageFormatTiff = "tiff"
+	ImageFormatBmp  = "bmp"
+)
+
+var supportedImageFormats = []string{ImageFormatJpeg, ImageFormatPng, ImageFormatGif, ImageFormatWebp,
+	ImageFormatHeic, ImageFormatAvif, ImageFormatTiff, ImageFormatBmp}
+
+var imageFormatsByMIME = map[string]string{
+	"image/jpeg":          ImageFormatJpeg,
+	"image/jpg":           ImageFormatJpeg,
+	"image/pjpeg":         ImageFormatJpeg,
+	"image/png":           ImageFormatPng,
+	"image/gif":           ImageFormatGif,
+	"image/webp":          ImageFormatWebp,
+	"image/heic":          ImageFormatHeic,
+	"image/heif":          ImageFormatHeic,
+	"image/heic-sequence": ImageFormatHeic,
+	"image/heif-sequence": ImageFormatHeic,
+	"image/avif":          ImageFormatAvif,
+	"image/tiff":          ImageFormatTiff,
+	"image/bmp":           ImageFormatBmp,
+	"image/x-ms-bmp":      ImageFormatBmp,
+}
+
+var imageFormatsByExtension = map[string]string{
+	".jpg":  ImageFormatJpeg,
+	".jpeg": ImageFormatJpeg,
+	".png":  ImageFormatPng,
+	".gif":  ImageFormatGif,
+	".webp": ImageFormatWebp,
+	".heic": ImageFormatHeic,
+	".heif": ImageFormatHeic,
+	".avif": ImageFormatAvif,
+	".tif":  ImageFormatTiff,
+	".tiff": ImageFormatTiff,
+	".bmp":  ImageFormatBmp,
+}
+
+// DetectImageFormat detects format of the image by its content, returns "" if it's not an image known to joi
+func DetectImageFormat(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	header := make([]byte, 32)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return detectImageFormat(header[:n]), nil
+}
+
+func detectImageFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ImageFormatJpeg
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return ImageFormatPng
+	case bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a")):
+		return ImageFormatGif
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return ImageFormatWebp
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return ImageFormatTiff
+	case bytes.HasPrefix(header, []byte("BM")):
+		return ImageFormatBmp
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		// ISO base media file, the major brand tells what's inside
+		switch string(header[8:12]) {
+		case "avif", "avis":
+			return ImageFormatAvif
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return ImageFormatHeic
+		}
+	}
+	return ""
+}
+
+// imageFormatOfDocument guesses format of the image sent as a document by its MIME type or its file name,
+// returns "" if the document doesn't look like an image at all
+func imageFormatOfDocument(mime string, filename string) string {
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	if format, ok := imageFormatsByMIME[mime]; ok {
+		return format
+	}
+	if format, ok := imageFormatsByExtension[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
+	}
+	if strings.HasPrefix(mime, "image") {
+		return strings.TrimPrefix(mime, "image/")
+	}
+	return ""
+}
+
+func IsImageFormatSupported(format string) bool {
+	return contains(supportedImageFormats, format)
+}
+
+func needsDecoding(format string) bool {
+	return format == ImageFormatHeic || format == ImageFormatAvif || format == ImageFormatWebp
+}
+
+// canBeAnimated tells whether the format allows more than one frame, such images are sent as animations then
+func canBeAnimated(format string) bool {
+	return format == ImageFormatGif || format == ImageFormatWebp || format == ImageFormatPng || format == ImageFormatAvif
+}
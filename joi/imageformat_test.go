@@ -0,0 +1,53 @@
+package joi
+
+import (
+	"path"
+	"testing"
+)
+
+func TestDetectImageFormat(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"sample.jpg":    ImageFormatJpeg,
+		"sample.png":    ImageFormatPng,
+		"sample.gif":    ImageFormatGif,
+		"sample.webp":   ImageFormatWebp,
+		"animated.webp": ImageFormatWebp,
+		"sample.heic":   ImageFormatHeic,
+		"sample.avif":   ImageFormatAvif,
+		"sample.svg":    "",
+	} {
+		format, err := DetectImageFormat(path.Join("testdata", filename))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if format != expected {
+			t.Errorf("format of %s is detected as '%s', expected '%s'", filename, format, expected)
+		}
+	}
+}
+
+func TestImageFormatOfDocument(t *testing.T) {
+	for _, test := range []struct {
+		mime, filename string
+		format         string
+		supported      bool
+	}{
+		{"image/jpeg", "art.jpg", ImageFormatJpeg, true},
+		{"image/heic", "IMG_0001.HEIC", ImageFormatHeic, true},
+		{"image/heif", "IMG_0001.heif", ImageFormatHeic, true},
+		{"application/octet-stream", "IMG_0001.HEIC", ImageFormatHeic, true},
+		{"image/webp", "art.webp", ImageFormatWebp, true},
+		{"image/avif", "art.avif", ImageFormatAvif, true},
+		{"image/svg+xml", "logo.svg", "svg+xml", false},
+		{"image/vnd.adobe.photoshop", "art.psd", "vnd.adobe.photoshop", false},
+		{"application/pdf", "art.pdf", "", false},
+	} {
+		format := imageFormatOfDocument(test.mime, test.filename)
+		if format != test.format {
+			t.Errorf("format of %s (%s) is '%s', expected '%s'", test.filename, test.mime, format, test.format)
+		}
+		if IsImageFormatSupported(format) != test.supported {
+			t.Errorf("format '%s' is expected to be supported=%t", format, test.supported)
+		}
+	}
+}
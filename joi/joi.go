@@ -10,6 +10,7 @@ import (
 	"math"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,8 +24,15 @@ type Joi struct {
 	Database  *Database
 	Converter *Converter
 
-	worker     *PostWorker
-	configPath string
+	worker      *PostWorker
+	hotFolder   *HotFolderWatcher // nil unless the hot folder is configured
+	pool        *ConversionPool   // background conversions and hashing of the queued posts
+	postingPool *ConversionPool   // conversions of the posts being sent, so they never wait for the background ones
+	pollWizards *pollWizards
+	postEditors *postEditors
+	configPath  string
+
+	defaultPostText FormattedText // parsed Cfg.DefaultPostText
 }
 
 func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error) {
@@ -52,6 +60,14 @@ func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error)
 		}
 	}
 	joi.Cfg = cfg
+	joi.defaultPostText, err = parseMarkup(cfg.DefaultPostText, cfg.ParseMode)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s\nfor default post text '%s'", err.Error(), cfg.DefaultPostText))
+	}
+	err = joi.checkTemplates(joi.defaultPostText.Text)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s\nfor default post text", err.Error()))
+	}
 
 	settings_ := tele.Settings{
 		Token:       cfg.Token,
@@ -63,13 +79,12 @@ func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error)
 	if len(settings) > 0 {
 		settings_ = settings[0]
 	}
-	bot, err := tele.NewBot(settings_)
-	if err != nil {
-		return nil, err
-	}
-	bot.OnError = func(err error, ctx tele.Context) {
+	settings_.OnError = func(err error, ctx tele.Context) {
 		report := err.Error()
 		log.Printf(report)
+		if ctx == nil {
+			return
+		}
 		if IsErrRedisNotFound(err) {
 			report = "not found in the database"
 		}
@@ -78,6 +93,10 @@ func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error)
 			log.Printf(err.Error())
 		}
 	}
+	bot, err := tele.NewBot(settings_)
+	if err != nil {
+		return nil, err
+	}
 
 	_, err = bot.ChatMemberOf(&tele.Chat{ID: cfg.ChannelId}, bot.Me)
 	if err != nil {
@@ -94,8 +113,36 @@ func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error)
 		DB:   cfg.RedisDatabaseNumber,
 	})
 	joi.Database = redisDB
+	// posts used to be stored as MarkdownV2 made from entities, or in the parse mode, if given by the config
+	migrated, err := redisDB.MigrateFormatting(tele.ModeMarkdownV2, cfg.ParseMode)
+	if err != nil {
+		return nil, err
+	}
+	if migrated > 0 {
+		log.Printf("formatting of %d posts is migrated to entities", migrated)
+	}
 
 	joi.Converter = NewConverter()
+	joi.pool = NewConversionPool(cfg.ConversionParallelism, time.Duration(cfg.ConversionTimeoutSeconds)*time.Second)
+	joi.postingPool = NewConversionPool(cfg.ConversionParallelism, time.Duration(cfg.ConversionTimeoutSeconds)*time.Second)
+	joi.pollWizards = &pollWizards{wizards: map[int64]*pollWizard{}}
+	joi.postEditors = &postEditors{editors: map[int64]*postEditor{}}
+	if cfg.HotFolder != "" {
+		err = os.MkdirAll(cfg.HotFolderArchive, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+		joi.hotFolder = NewHotFolderWatcher(joi, cfg.HotFolder, cfg.HotFolderArchive, time.Duration(cfg.HotFolderPollingSeconds)*time.Second)
+		joi.hotFolder.OnError = func(err error) {
+			log.Printf("%s", err.Error())
+			for _, adminId := range cfg.AdminList {
+				_, sendErr := bot.Send(&tele.Chat{ID: adminId}, err.Error())
+				if sendErr == nil {
+					break
+				}
+			}
+		}
+	}
 	joi.worker = NewPostWorker(joi, time.Minute)
 	joi.worker.OnError = func(err error) {
 		if !IsErrRedisNotFound(err) {
@@ -108,12 +155,24 @@ func NewJoi(config interface{}, settings ...tele.Settings) (joi *Joi, err error)
 
 func (joi *Joi) Start() {
 	go joi.worker.Start()
+	if joi.hotFolder != nil {
+		go joi.hotFolder.Start()
+	}
 
 	err := joi.Bot.SetCommands(
 		[]tele.Command{
 			{
 				Text:        "/info",
 				Description: "get info about the database/provided post",
+			}, {
+				Text:        "/edit",
+				Description: "edit the post with buttons",
+			}, {
+				Text:        "/list",
+				Description: "list the queue, filtered by slot, #tag or admin id (i.e. /list 06:06 #art)",
+			}, {
+				Text:        "/find",
+				Description: "find posts by a part of the text or the comment",
 			}, {
 				Text:        "/preview",
 				Description: "get the post preview in this chat",
@@ -135,9 +194,24 @@ func (joi *Joi) Start() {
 			}, {
 				Text:        "/protected",
 				Description: "toggle is_protected flag",
+			}, {
+				Text:        "/text",
+				Description: "add a text only post (or just forward a text message)",
+			}, {
+				Text:        "/webpreview",
+				Description: "toggle link preview of the text post",
+			}, {
+				Text:        "/force",
+				Description: "post the held possible duplicate by the schedule anyway",
+			}, {
+				Text:        "/poll",
+				Description: "add a poll step by step (or just forward a poll)",
+			}, {
+				Text:        "/cancel",
+				Description: "cancel adding the poll or editing the post",
 			}, {
 				Text:        "/schedule",
-				Description: "change schedule to (i.e. /schedule 06:06 21:21), note: saved only in RAM",
+				Description: "change schedule to (i.e. /schedule 06:06#morning 21:21), note: saved only in RAM",
 			}, {
 				Text:        "/rollback",
 				Description: "rollback the last change of the config",
@@ -165,13 +239,59 @@ func (joi *Joi) Start() {
 					float64(msg.Video.FileSize)/megabyte, TelegramMaximumFileSizeAllowed/megabyte))
 				return err
 			}
+			if msg.Animation != nil && msg.Animation.FileSize > TelegramMaximumFileSizeAllowed {
+				_, err := joi.Bot.Reply(msg, fmt.Sprintf("%.2fMB is too big, maximum Telegram allows - %dMB",
+					float64(msg.Animation.FileSize)/megabyte, TelegramMaximumFileSizeAllowed/megabyte))
+				return err
+			}
+			if msg.Audio != nil && msg.Audio.FileSize > TelegramMaximumFileSizeAllowed {
+				_, err := joi.Bot.Reply(msg, fmt.Sprintf("%.2fMB is too big, maximum Telegram allows - %dMB",
+					float64(msg.Audio.FileSize)/megabyte, TelegramMaximumFileSizeAllowed/megabyte))
+				return err
+			}
+			if msg.Voice != nil && msg.Voice.FileSize > TelegramMaximumFileSizeAllowed {
+				_, err := joi.Bot.Reply(msg, fmt.Sprintf("%.2fMB is too big, maximum Telegram allows - %dMB",
+					float64(msg.Voice.FileSize)/megabyte, TelegramMaximumFileSizeAllowed/megabyte))
+				return err
+			}
+		}
+		base := &PostInfo{
+			Text:         joi.defaultPostText.Text,
+			TextEntities: joi.defaultPostText.Entities,
+			Buttons:      joi.Cfg.DefaultButtons,
+		}
+		// the first captioned item of the album provides the text and the comment
+		for _, msg := range messages {
+			if msg.Caption == "" {
+				continue
+			}
+			text, textEntities, comment, commentEntities := splitCaption(msg.Caption, msg.CaptionEntities, joi.Cfg.CaptionCommentMarker)
+			if text != "" {
+				base.Text, base.TextEntities = text, textEntities
+			}
+			base.Comment, base.CommentEntities = comment, commentEntities
+			break
+		}
+		err := joi.attribute(base, messages[0])
+		if err != nil {
+			return err
 		}
-		_, err := joi.Database.AddPostFromMessages(&PostInfo{
-			Text: joi.Cfg.DefaultPostText,
-		}, messages...)
+		post, err := joi.addPost(base, messages...)
 		if err != nil {
 			return err
 		}
+		go joi.preconvertPost(post)
+		go joi.checkDuplicates(post)
+
+		albumsNumber := len(post.Albums())
+		for _, i := range post.UngroupableFiles() {
+			_, err = joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[i]), Chat: &tele.Chat{ID: post.AdminPostedId}},
+				fmt.Sprintf("item %d (%s) can't be in the same album with item %d (%s), the post will be sent as %d messages",
+					i+1, post.Files[i].TypeName(), i, post.Files[i-1].TypeName(), albumsNumber))
+			if err != nil {
+				return err
+			}
+		}
 
 		joi.sendExpiring(time.Second*30, messages[0].Chat, "+", &tele.SendOptions{ReplyTo: messages[0]})
 
@@ -189,7 +309,7 @@ func (joi *Joi) Start() {
 				return err
 			}
 
-			_, err = joi.worker.PostExtended(post, ctx.Chat().ID, &tele.SendOptions{ReplyTo: ctx.Message(), ParseMode: joi.Cfg.ParseMode}, false)
+			_, err = joi.worker.PostExtended(post, ctx.Chat().ID, &tele.SendOptions{ReplyTo: ctx.Message(), ParseMode: joi.parseMode()}, false)
 			if err != nil {
 				return err
 			}
@@ -199,7 +319,7 @@ func (joi *Joi) Start() {
 				return err
 			}
 			for _, post := range posts {
-				_, err = joi.worker.PostExtended(post, ctx.Chat().ID, &tele.SendOptions{ReplyTo: ctx.Message(), ParseMode: joi.Cfg.ParseMode}, false)
+				_, err = joi.worker.PostExtended(post, ctx.Chat().ID, &tele.SendOptions{ReplyTo: ctx.Message(), ParseMode: joi.parseMode()}, false)
 				if err != nil {
 					_, _ = joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[0]), Chat: &tele.Chat{ID: post.AdminPostedId}}, "smth wrong with this message")
 					return err
@@ -227,7 +347,15 @@ func (joi *Joi) Start() {
 	admin.Handle("/info", func(ctx tele.Context) error {
 		post, err := joi.extractLinkedPost(ctx)
 		if err == nil {
-			_, err = joi.Bot.Reply(ctx.Message(), fmt.Sprintf("will be posted at %s, text: \"%s\", comment: \"%s\"\nid:%s", post.Time, post.Text, post.Comment, post.Id))
+			held := ""
+			if post.Held {
+				held = " (held as a possible duplicate, reply .force to post it anyway)"
+			}
+			tags := ""
+			if len(post.Tags) > 0 {
+				tags = fmt.Sprintf(", tags: %s", formatTags(post.Tags))
+			}
+			_, err = joi.Bot.Reply(ctx.Message(), fmt.Sprintf("will be posted at %s%s, text: \"%s\", comment: \"%s\"%s\nid:%s", post.Time, held, post.Text, post.Comment, tags, post.Id))
 			return nil
 		}
 
@@ -263,15 +391,16 @@ func (joi *Joi) Start() {
 		sort.Strings(joi.Cfg.DefaultPostTimes)
 		defaultPostsCount := make([]int, len(joi.Cfg.DefaultPostTimes))
 		for i, t := range specifiedTimes {
-			for j, defaultTime := range joi.Cfg.DefaultPostTimes {
-				if t == defaultTime {
+			for j, entry := range joi.Cfg.DefaultPostTimes {
+				if defaultTime, _ := parseScheduleEntry(entry); t == defaultTime {
 					defaultPostsCount[j] = postsCounts[i]
 					break
 				}
 			}
 		}
 
-		for _, defaultTime := range joi.Cfg.DefaultPostTimes {
+		for _, entry := range joi.Cfg.DefaultPostTimes {
+			defaultTime, _ := parseScheduleEntry(entry)
 			contains := false
 			for _, t := range specifiedTimes {
 				if t == defaultTime {
@@ -300,9 +429,17 @@ func (joi *Joi) Start() {
 		return ctx.Send(strings.Join(reportLines, "\n"))
 	})
 
-	admin.Handle(tele.OnText, func(ctx tele.Context) error {
+	onAdminText := func(ctx tele.Context) error {
 		msgText := strings.Trim(ctx.Message().Text, " \n\r")
+		if wizard := joi.pollWizards.get(ctx.Sender().ID); wizard != nil && wizard.waitsFor(ctx.Message()) {
+			return joi.continuePollWizard(ctx, wizard)
+		}
+		if editor := joi.postEditors.get(ctx.Sender().ID); editor != nil && editor.waitsFor(ctx.Message()) {
+			return joi.continuePostEditor(ctx, editor)
+		}
 		switch {
+		case ctx.Message().ReplyTo == nil && ctx.Message().IsForwarded():
+			return joi.addTextPost(ctx.Message(), ctx.Message().Text, ctx.Message().Entities)
 		case isTimeValid(msgText):
 			post, err := joi.extractLinkedPost(ctx)
 			if err != nil {
@@ -321,22 +458,10 @@ func (joi *Joi) Start() {
 			}
 			switch {
 			case contains([]string{".s", ".src", ".source", "/source"}, msgText):
-				postSources := PostSourcesAuto
-				if post.PostSources == PostSourcesTrue {
-					postSources = PostSourcesFalse
-				} else if post.PostSources == PostSourcesFalse {
-					postSources = PostSourcesTrue
-				} else {
-					postSources = PostSourcesTrue // one day I'll implement auto-posting of sources
-				}
-				if postSources == PostSourcesTrue {
-					for _, file := range post.Files {
-						if file.Type != TelegramFileTypeDocPhoto && file.Type != TelegramFileTypeDocVideo {
-							return ctx.Reply("there's no sources to post, therefore nothing is changed")
-						}
-					}
+				newPost, err := joi.togglePostSources(post)
+				if err == errNoSources {
+					return ctx.Reply(err.Error())
 				}
-				newPost, err := joi.Database.ChangePost(post.Id, ChangePostPostSources, postSources)
 				if err != nil {
 					return err
 				}
@@ -347,16 +472,106 @@ func (joi *Joi) Start() {
 					return err
 				}
 				return ctx.Reply(fmt.Sprintf("post protection %t -> %t", post.IsProtected, newPost.IsProtected))
+			case contains([]string{".wp", ".webpreview", "/webpreview"}, msgText):
+				newPost, err := joi.Database.ChangePost(post.Id, ChangePostDisableWebPagePreview, !post.DisableWebPagePreview)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("link preview %t -> %t", !post.DisableWebPagePreview, !newPost.DisableWebPagePreview))
+			case contains([]string{".force", "/force"}, msgText):
+				if !post.Held {
+					return ctx.Reply("the post isn't held")
+				}
+				_, err := joi.Database.ChangePost(post.Id, ChangePostHeld, false)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply("the post will be posted by the schedule anyway")
+			case isDotCommand(msgText, ".merge"):
+				args := strings.Fields(msgText)[1:]
+				if len(args) != 1 {
+					return ctx.Reply("usage: .merge <id of the post to append>")
+				}
+				newPost, err := joi.Database.MergePosts(post.Id, args[0], joi.checkPost)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("merged, post %s has %d items now", newPost.Id, len(newPost.Files)))
+			case isDotCommand(msgText, ".split"):
+				items, err := parseItemNumbers(strings.Fields(msgText)[1:])
+				if err != nil || len(items) != 1 {
+					return ctx.Reply("usage: .split <number of the first item of the new post>")
+				}
+				first, second, err := joi.Database.SplitPost(post.Id, items[0], joi.checkPost)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("split into %s of %d items and %s of %d items", first.Id, len(first.Files), second.Id, len(second.Files)))
+			case isDotCommand(msgText, ".order"):
+				order, err := parseItemNumbers(strings.Fields(msgText)[1:])
+				if err != nil || len(order) == 0 {
+					return ctx.Reply("usage: .order <numbers of the items in the new order>, i.e. .order 3 1 2")
+				}
+				_, err = joi.Database.ReorderPost(post.Id, order, joi.checkPost)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply("reordered.")
+			case isDotCommand(msgText, ".drop"):
+				items, err := parseItemNumbers(strings.Fields(msgText)[1:])
+				if err != nil || len(items) != 1 {
+					return ctx.Reply("usage: .drop <number of the item>")
+				}
+				newPost, err := joi.Database.DropPostItem(post.Id, items[0], joi.checkPost)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("dropped, %d items left", len(newPost.Files)))
+			case isDotCommand(msgText, ".tag"):
+				// '.tag a #b c' sets tags of the post, '.tag -' removes all of them
+				args := strings.Fields(msgText)[1:]
+				tags := make([]string, 0)
+				if len(args) == 0 {
+					return ctx.Reply("usage: .tag <tags separated by spaces>, or .tag - to remove them")
+				}
+				if !(len(args) == 1 && args[0] == "-") {
+					tags, err = parseTags(args)
+					if err != nil {
+						return ctx.Reply(err.Error())
+					}
+				}
+				newPost, err := joi.Database.ChangePost(post.Id, ChangePostTags, tags)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("post tags \"%s\" -> \"%s\"", formatTags(post.Tags), formatTags(newPost.Tags)))
+			case isDotCommand(msgText, ".btn"):
+				// '.btn Title | https://...' adds buttons, one per line, '.btn -' removes all of them
+				buttonsText := strings.TrimSpace(msgText[len(".btn"):])
+				buttons := make([]PostButton, 0)
+				if buttonsText != "-" {
+					parsed, err := parsePostButtons(buttonsText)
+					if err != nil {
+						return ctx.Reply(err.Error())
+					}
+					buttons = append(append(buttons, post.Buttons...), parsed...)
+				}
+				newPost, err := joi.Database.ChangePost(post.Id, ChangePostButtons, buttons)
+				if err != nil {
+					return err
+				}
+				return ctx.Reply(fmt.Sprintf("post buttons %d -> %d", len(post.Buttons), len(newPost.Buttons)))
 			default:
 				if strings.HasSuffix(strings.ToLower(msgText), ".p") {
 					trimmed := strings.TrimRight(ctx.Message().Text, " \n\r")
-					newPost, err := joi.Database.ChangePost(post.Id, ChangePostText, tgMessageToMarkdown(trimmed[:len(trimmed)-len(".p")], ctx.Message().Entities))
+					text, entities := sliceText(ctx.Message().Text, ctx.Message().Entities, 0, utf16Length(trimmed)-len(".p"))
+					newPost, err := joi.changePostText(post, ChangePostText, FormattedText{Text: text, Entities: entities})
 					if err != nil {
 						return err
 					}
 					return ctx.Reply(fmt.Sprintf("post text \"%s\" -> \"%s\"", post.Text, newPost.Text))
 				} else {
-					newPost, err := joi.Database.ChangePost(post.Id, ChangePostComment, tgMessageToMarkdown(ctx.Message().Text, ctx.Message().Entities))
+					newPost, err := joi.changePostText(post, ChangePostComment, FormattedText{Text: ctx.Message().Text, Entities: ctx.Message().Entities})
 					if err != nil {
 						return err
 					}
@@ -365,13 +580,24 @@ func (joi *Joi) Start() {
 
 			}
 		}
+	}
+	admin.Handle("/text", func(ctx tele.Context) error {
+		if ctx.Message().Payload == "" {
+			return ctx.Reply("usage: /text <text of the post>")
+		}
+		payload, entities := commandPayload(ctx.Message().Text, ctx.Message().Entities)
+		return joi.addTextPost(ctx.Message(), payload, entities)
 	})
+	admin.Handle("/poll", joi.startPollWizard)
+	admin.Handle("/cancel", joi.cancelInput)
+	joi.handleEditor(admin)
+	joi.handleQueue(admin)
 	admin.Handle("/notext", func(ctx tele.Context) error {
 		post, err := joi.extractLinkedPost(ctx)
 		if err != nil {
 			return err
 		}
-		newPost, err := joi.Database.ChangePost(post.Id, ChangePostText, "")
+		newPost, err := joi.Database.ChangePost(post.Id, ChangePostText, FormattedText{})
 		if err != nil {
 			return err
 		}
@@ -382,7 +608,7 @@ func (joi *Joi) Start() {
 		if err != nil {
 			return err
 		}
-		newPost, err := joi.Database.ChangePost(post.Id, ChangePostComment, "")
+		newPost, err := joi.Database.ChangePost(post.Id, ChangePostComment, FormattedText{})
 		if err != nil {
 			return err
 		}
@@ -406,7 +632,7 @@ func (joi *Joi) Start() {
 	})
 	admin.Handle("/schedule", func(ctx tele.Context) error {
 		for _, t := range ctx.Args() {
-			if !isTimeValid(t) {
+			if !isScheduleEntryValid(t) {
 				return ctx.Reply(fmt.Sprintf("Time %s is invalid", t))
 			}
 		}
@@ -439,6 +665,8 @@ func (joi *Joi) Start() {
 	admin.Handle("/shutdown", func(ctx tele.Context) error {
 		if len(ctx.Args()) > 0 && strings.ToLower(ctx.Args()[0]) == "please" {
 			_ = ctx.Reply("shutting down...")
+			joi.pool.Stop()
+			joi.postingPool.Stop()
 			os.Exit(0)
 		} else {
 			return ctx.Reply("say 'please', be gentle")
@@ -446,6 +674,13 @@ func (joi *Joi) Start() {
 		return nil
 	})
 
+	joi.Bot.Handle(tele.OnText, func(ctx tele.Context) error {
+		if joi.isForwardedFromChannel(ctx) {
+			return joi.linkCommentsThread(ctx)
+		}
+		return personalMessagesOnly(middleware.Whitelist(joi.Cfg.AdminList...)(onAdminText))(ctx)
+	})
+
 	mediaRegistrator := albumHandler.Register()
 	joi.Bot.Handle(tele.OnMedia, func(ctx tele.Context) error {
 		// add media to db
@@ -456,14 +691,8 @@ func (joi *Joi) Start() {
 				}
 			}
 		}
-		// triggers only on a media from the channel in comments chat
-		if ctx.Chat().ID == joi.Cfg.CommentsId && ctx.Message().IsForwarded() && ctx.Sender().ID == 777000 {
-			if id, contains := joi.worker.GetPosted(ctx.Message().OriginalMessageID); contains {
-				_, err := joi.Database.ChangePost(id, ChangePostMsgIdInCommentsChat, ctx.Message().ID)
-				if err != nil && !IsErrRedisNotFound(err) {
-					return err
-				}
-			}
+		if joi.isForwardedFromChannel(ctx) {
+			return joi.linkCommentsThread(ctx)
 		}
 		return nil
 	})
@@ -477,9 +706,129 @@ func (joi *Joi) Start() {
 		return ctx.Send("don't touch me, pls. i'm fine by myself, i swear.")
 	})
 
+	// telebot doesn't route messages with polls to any handler, so they are intercepted right from the updates
+	onPoll := personalMessagesOnly(middleware.Whitelist(joi.Cfg.AdminList...)(joi.addPollPost))
+	joi.Bot.Poller = tele.NewMiddlewarePoller(joi.Bot.Poller, func(update *tele.Update) bool {
+		if update.Message == nil || update.Message.Poll == nil {
+			return true
+		}
+		ctx := joi.Bot.NewContext(*update)
+		var err error
+		if joi.isForwardedFromChannel(ctx) {
+			err = joi.linkCommentsThread(ctx)
+		} else {
+			err = onPoll(ctx)
+		}
+		if err != nil {
+			joi.Bot.OnError(err, ctx)
+		}
+		return false
+	})
+
 	joi.Bot.Start()
 }
 
+// Stop stops receiving updates and cancels all the conversions in progress
+func (joi *Joi) Stop() {
+	joi.Bot.Stop()
+	if joi.hotFolder != nil {
+		joi.hotFolder.Stop()
+	}
+	joi.pool.Stop()
+	joi.postingPool.Stop()
+}
+
+func (joi *Joi) addTextPost(msg *tele.Message, text string, entities tele.Entities) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("text of the post is empty")
+	}
+	base := &PostInfo{
+		Text:                  text,
+		TextEntities:          entities,
+		DisableWebPagePreview: joi.Cfg.DisableWebPagePreview,
+		Buttons:               joi.Cfg.DefaultButtons,
+	}
+	err := joi.attribute(base, msg)
+	if err != nil {
+		return err
+	}
+	_, err = joi.addPost(base, msg)
+	if err != nil {
+		return err
+	}
+
+	joi.sendExpiring(time.Second*30, msg.Chat, "+", &tele.SendOptions{ReplyTo: msg})
+	return nil
+}
+
+var errNoSources = errors.New("there's no sources to post, therefore nothing is changed")
+
+// togglePostSources switches posting of the sources, they can be posted only if every file of the post has one
+func (joi *Joi) togglePostSources(post *PostInfo) (*PostInfo, error) {
+	postSources := PostSourcesAuto
+	if post.PostSources == PostSourcesTrue {
+		postSources = PostSourcesFalse
+	} else if post.PostSources == PostSourcesFalse {
+		postSources = PostSourcesTrue
+	} else {
+		postSources = PostSourcesTrue // one day I'll implement auto-posting of sources
+	}
+	if postSources == PostSourcesTrue {
+		if len(post.Files) == 0 {
+			return nil, errNoSources
+		}
+		for _, file := range post.Files {
+			if !file.HasSource() {
+				return nil, errNoSources
+			}
+		}
+	}
+	candidate := *post
+	candidate.PostSources = postSources
+	err := joi.checkPost(&candidate)
+	if err != nil {
+		return nil, err
+	}
+	return joi.Database.ChangePost(post.Id, ChangePostPostSources, postSources)
+}
+
+// changePostText changes the text (ChangePostText) or the comment (ChangePostComment) of the post,
+// unless it becomes an invalid template or longer, than Telegram allows
+func (joi *Joi) changePostText(post *PostInfo, what int, text FormattedText) (*PostInfo, error) {
+	candidate := *post
+	if what == ChangePostText {
+		candidate.Text, candidate.TextEntities = text.Text, text.Entities
+	} else {
+		candidate.Comment, candidate.CommentEntities = text.Text, text.Entities
+	}
+	err := joi.checkPost(&candidate)
+	if err != nil {
+		return nil, err
+	}
+	return joi.Database.ChangePost(post.Id, what, text)
+}
+
+// addPost adds the post from the messages, unless its text or comment is invalid or longer, than Telegram allows
+func (joi *Joi) addPost(base *PostInfo, msgs ...*tele.Message) (*PostInfo, error) {
+	return joi.Database.AddPostFromMessages(base, joi.checkPost, msgs...)
+}
+
+// isForwardedFromChannel tells whether the message is a post automatically forwarded from the channel to the comments chat
+func (joi *Joi) isForwardedFromChannel(ctx tele.Context) bool {
+	return ctx.Chat().ID == joi.Cfg.CommentsId && ctx.Message().IsForwarded() && ctx.Sender().ID == 777000
+}
+
+// linkCommentsThread remembers, where in the comments chat the comments of the freshly posted post should go
+func (joi *Joi) linkCommentsThread(ctx tele.Context) error {
+	if id, isThread, contains := joi.worker.GetPosted(ctx.Message().OriginalMessageID); contains && isThread {
+		_, err := joi.Database.ChangePost(id, ChangePostMsgIdInCommentsChat, ctx.Message().ID)
+		if err != nil && !IsErrRedisNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (joi *Joi) sendExpiring(lifetime time.Duration, chat *tele.Chat, what interface{}, opts ...interface{}) {
 	go func() {
 		msg, err := joi.Bot.Send(chat, what, opts...)
@@ -519,7 +868,11 @@ func (joi *Joi) extractLinkedPost(ctx tele.Context) (*PostInfo, error) {
 	}
 
 	if ctx.Message().ReplyTo != nil {
-		post, err := joi.Database.GetPost(mediaGroupToId(ctx.Message().ReplyTo))
+		// messages of merged, split or reordered posts don't match the post id anymore
+		post, err := joi.Database.GetPostByMessage(ctx.Message().ReplyTo.Chat.ID, ctx.Message().ReplyTo.ID)
+		if IsErrRedisNotFound(err) {
+			post, err = joi.Database.GetPost(mediaGroupToId(ctx.Message().ReplyTo))
+		}
 		if err != nil {
 			return nil, err
 		} else {
@@ -582,48 +935,28 @@ func maximizeMinimalNumber(array []int, incrementsAllowed int) int {
 }
 
 func escapeTgMarkdownV2SpecialSymbols(text string) string {
-	// escape chars: '_', '*', '[', ']', '(', ')', '~', '`', '>', '#', '+', '-', '=', '|', '{', '}', '.', '!'
-	replacer := strings.NewReplacer("_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!")
+	// escape chars: '\\', '_', '*', '[', ']', '(', ')', '~', '`', '>', '#', '+', '-', '=', '|', '{', '}', '.', '!'
+	replacer := strings.NewReplacer("\\", "\\\\", "_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!")
 	return replacer.Replace(text)
 }
 
-func tgMessageToMarkdown(text string, entities tele.Entities) string {
-	markdownString := make([]string, len(text))
-	for i, char := range []rune(text) {
-		markdownString[i] = escapeTgMarkdownV2SpecialSymbols(string(char))
-	}
+// isDotCommand tells whether the text is the command alone or followed by its arguments, i.e. '.btn' but not '.btnfoo'
+func isDotCommand(text string, command string) bool {
+	lower := strings.ToLower(text)
+	return lower == command || strings.HasPrefix(lower, command+" ") || strings.HasPrefix(lower, command+"\n")
+}
 
-	for _, entity := range entities {
-		switch {
-		case entity.Type == tele.EntityItalic:
-			markdownString[entity.Offset] = "_" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "_"
-		case entity.Type == tele.EntityBold:
-			markdownString[entity.Offset] = "*" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "*"
-		case entity.Type == tele.EntityStrikethrough:
-			markdownString[entity.Offset] = "~" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "~"
-		case entity.Type == tele.EntitySpoiler:
-			markdownString[entity.Offset] = "||" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "||"
-		case entity.Type == tele.EntityCode:
-			markdownString[entity.Offset] = "`" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "`"
-		case entity.Type == tele.EntityCodeBlock:
-			markdownString[entity.Offset] = "```" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += "```"
-		case entity.Type == tele.EntityTextLink:
-			markdownString[entity.Offset] = "[" + markdownString[entity.Offset]
-			markdownString[entity.Offset+entity.Length-1] += fmt.Sprintf("](%s)", escapeTgMarkdownV2SpecialSymbols(entity.URL))
-		case entity.Type == tele.EntityMention:
-		case entity.Type == tele.EntityURL:
-		default:
-			log.Printf("entity of type %s is not supported", entity.Type)
+// parseItemNumbers parses numbers of the items of the post (from 1) into indexes (from 0)
+func parseItemNumbers(args []string) ([]int, error) {
+	indexes := make([]int, len(args))
+	for i, arg := range args {
+		number, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, err
 		}
+		indexes[i] = number - 1
 	}
-
-	return strings.Join(markdownString, "")
+	return indexes, nil
 }
 
 func contains(array []string, value string) bool {
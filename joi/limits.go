@@ -0,0 +1,105 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Telegram counts the length of the text after entities parsing, in UTF-16 code units
+const (
+	TelegramMaximumCaptionLength = 1024
+	TelegramMaximumMessageLength = 4096
+)
+
+// TextLimit returns the maximum length of the text, it's a caption, unless it's sent as a separate message
+func (post *PostInfo) TextLimit() int {
+	if post.CaptionIndex() >= 0 {
+		return TelegramMaximumCaptionLength
+	}
+	return TelegramMaximumMessageLength
+}
+
+// CommentLimit returns the maximum length of the comment, it's a caption of the last source, if sources are posted
+func (post *PostInfo) CommentLimit() int {
+	if post.PostSources == PostSourcesTrue {
+		return TelegramMaximumCaptionLength
+	}
+	return TelegramMaximumMessageLength
+}
+
+// joinText appends the other formatted text to the text, entities of the other one are shifted accordingly
+func joinText(text FormattedText, other FormattedText, separator string) FormattedText {
+	if text.Text == "" {
+		return other
+	}
+	if other.Text == "" {
+		return text
+	}
+	joined := FormattedText{Text: text.Text + separator + other.Text}
+	joined.Entities = append(joined.Entities, text.Entities...)
+	shift := utf16Length(text.Text + separator)
+	for _, entity := range other.Entities {
+		entity.Offset += shift
+		joined.Entities = append(joined.Entities, entity)
+	}
+	return joined
+}
+
+// overflowText returns a copy of the post, which text fits the limit, the excess is moved to the beginning of the comment.
+// The text is cut at the last line break or space before the limit, unless it is too far from it.
+func overflowText(post *PostInfo) *PostInfo {
+	limit := post.TextLimit()
+	encoded := utf16.Encode([]rune(post.Text))
+	if len(encoded) <= limit {
+		return post
+	}
+
+	cut := limit
+	if encoded[cut-1] >= 0xD800 && encoded[cut-1] < 0xDC00 {
+		cut-- // the surrogate pair isn't split
+	}
+	head := string(utf16.Decode(encoded[:cut]))
+	if boundary := strings.LastIndexAny(head, "\n "); boundary >= 0 && utf16Length(head[:boundary]) > limit/2 {
+		cut = utf16Length(head[:boundary])
+	}
+
+	overflown := *post
+	text, textEntities := sliceText(post.Text, post.TextEntities, 0, cut)
+	overflown.Text, overflown.TextEntities = trimText(text, textEntities)
+	excess, excessEntities := sliceText(post.Text, post.TextEntities, cut, len(encoded))
+	excess, excessEntities = trimText(excess, excessEntities)
+	comment := joinText(FormattedText{Text: excess, Entities: excessEntities}, FormattedText{Text: post.Comment, Entities: post.CommentEntities}, "\n\n")
+	overflown.Comment, overflown.CommentEntities = comment.Text, comment.Entities
+	return &overflown
+}
+
+// checkPost rejects the post, if its text or comment isn't a valid template or is longer, than Telegram allows,
+// the length is counted for templates executed on sample values
+func (joi *Joi) checkPost(post *PostInfo) error {
+	err := joi.checkTemplates(post.Text, post.Comment)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderPostTemplates(post, joi.postTemplateData(post, time.Now(), 1, "https://t.me/channel"))
+	if err != nil {
+		return err
+	}
+
+	overflown := ""
+	if joi.Cfg.OverflowTextToComment {
+		rendered = overflowText(rendered)
+		overflown = ", together with the overflown text"
+	}
+	if length := utf16Length(rendered.Text); length > rendered.TextLimit() {
+		return errors.New(fmt.Sprintf("the text is %d characters long, while Telegram allows %d, cut %d characters",
+			length, rendered.TextLimit(), length-rendered.TextLimit()))
+	}
+	if length := utf16Length(rendered.Comment); length > rendered.CommentLimit() {
+		return errors.New(fmt.Sprintf("the comment is %d characters long%s, while Telegram allows %d, cut %d characters",
+			length, overflown, rendered.CommentLimit(), length-rendered.CommentLimit()))
+	}
+	return nil
+}
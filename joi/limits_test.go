@@ -0,0 +1,71 @@
+package joi
+
+import (
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"strings"
+	"testing"
+)
+
+func TestOverflowText(t *testing.T) {
+	photo := []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "photo"}}
+	text := strings.Repeat("слово ", 200) + "😀" // 1202 characters
+	post := &PostInfo{
+		Text:         text,
+		TextEntities: tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: utf16Length(text)}},
+		Comment:      "comment",
+		Files:        photo,
+	}
+
+	overflown := overflowText(post)
+	textLength := utf16Length(overflown.Text)
+	if textLength > TelegramMaximumCaptionLength || !strings.HasSuffix(overflown.Text, "слово") {
+		t.Errorf("text of %d characters is cut wrong", textLength)
+	}
+	if !strings.HasPrefix(overflown.Comment, "слово") || !strings.HasSuffix(overflown.Comment, "😀\n\ncomment") {
+		t.Errorf("comment is '%s'", overflown.Comment)
+	}
+	excessLength := utf16Length(overflown.Comment) - utf16Length("\n\ncomment")
+	if formatTestEntities(overflown.TextEntities) != fmt.Sprintf("[bold 0 %d]", textLength) ||
+		formatTestEntities(overflown.CommentEntities) != fmt.Sprintf("[bold 0 %d]", excessLength) {
+		t.Errorf("entities are %s and %s", formatTestEntities(overflown.TextEntities), formatTestEntities(overflown.CommentEntities))
+	}
+
+	textOnly := &PostInfo{Text: text}
+	if overflowText(textOnly) != textOnly {
+		t.Errorf("text only post within %d characters is overflown", TelegramMaximumMessageLength)
+	}
+}
+
+func TestJoi_CheckPost(t *testing.T) {
+	joi := &Joi{}
+	photo := []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "photo"}}
+
+	for _, test := range []struct {
+		post     *PostInfo
+		expected string
+	}{
+		{&PostInfo{Text: strings.Repeat("я", 1024), Files: photo}, ""},
+		{&PostInfo{Text: strings.Repeat("😀", 513), Files: photo}, "the text is 1026 characters long, while Telegram allows 1024, cut 2 characters"},
+		{&PostInfo{Text: strings.Repeat("я", 1020) + "{{.Date}}", Files: photo}, "the text is 1030 characters long, while Telegram allows 1024, cut 6 characters"},
+		{&PostInfo{Text: strings.Repeat("я", 1030)}, ""},
+		{&PostInfo{Comment: strings.Repeat("я", 1030), Files: photo, PostSources: PostSourcesTrue}, "the comment is 1030 characters long, while Telegram allows 1024, cut 6 characters"},
+		{&PostInfo{Comment: strings.Repeat("я", 1030), Files: photo, PostSources: PostSourcesFalse}, ""},
+	} {
+		err := joi.checkPost(test.post)
+		if err == nil && test.expected != "" || err != nil && err.Error() != test.expected {
+			t.Errorf("post of %d characters long text and %d characters long comment is checked as %v",
+				utf16Length(test.post.Text), utf16Length(test.post.Comment), err)
+		}
+	}
+
+	joi.Cfg.OverflowTextToComment = true
+	err := joi.checkPost(&PostInfo{Text: strings.Repeat("я ", 600), Files: photo})
+	if err != nil {
+		t.Errorf("overflowing caption is rejected: %s", err.Error())
+	}
+	err = joi.checkPost(&PostInfo{Text: strings.Repeat("я ", 600), Comment: strings.Repeat("я", 4000), Files: photo})
+	if err == nil || !strings.Contains(err.Error(), "together with the overflown text") {
+		t.Errorf("comment overflown beyond the limit is checked as %v", err)
+	}
+}
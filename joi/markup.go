@@ -0,0 +1,403 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ModeEntities isn't a parse mode, texts are sent as is along with their native entities
+const ModeEntities = "Entities"
+
+// EntityExpandableBlockquote is the quote, which is collapsed until the reader expands it, unknown to telebot yet
+const EntityExpandableBlockquote tele.EntityType = "expandable_blockquote"
+
+const (
+	tgUserLinkPrefix  = "tg://user?id="
+	tgEmojiLinkPrefix = "tg://emoji?id="
+)
+
+// FormattedText is the plain text with the entities, which Telegram describes its formatting with
+type FormattedText struct {
+	Text     string
+	Entities tele.Entities
+}
+
+// parseMarkup turns the text marked up in the parse mode into the plain text with entities,
+// texts without a parse mode (or with native entities) are plain already
+func parseMarkup(text string, parseMode string) (FormattedText, error) {
+	switch parseMode {
+	case tele.ModeMarkdownV2:
+		return parseMarkdownV2(text)
+	case tele.ModeMarkdown:
+		return parseLegacyMarkdown(text)
+	case tele.ModeHTML:
+		return parseHTML(text)
+	default:
+		return FormattedText{Text: text}, nil
+	}
+}
+
+// entitiesBuilder collects the plain text and the entities, while the markup is parsed
+type entitiesBuilder struct {
+	text     []rune
+	length   int                  // of the text in UTF-16 code units
+	opened   []tele.MessageEntity // the length isn't known yet
+	entities tele.Entities
+}
+
+func (builder *entitiesBuilder) write(text string) {
+	builder.text = append(builder.text, []rune(text)...)
+	builder.length += utf16Length(text)
+}
+
+func (builder *entitiesBuilder) open(entity tele.MessageEntity) {
+	entity.Offset = builder.length
+	builder.opened = append(builder.opened, entity)
+}
+
+// innermost returns the last opened entity of the type, nil if there's none
+func (builder *entitiesBuilder) innermost(entityType tele.EntityType) *tele.MessageEntity {
+	for i := len(builder.opened) - 1; i >= 0; i-- {
+		if builder.opened[i].Type == entityType {
+			return &builder.opened[i]
+		}
+	}
+	return nil
+}
+
+func (builder *entitiesBuilder) close(entityType tele.EntityType) error {
+	for i := len(builder.opened) - 1; i >= 0; i-- {
+		entity := builder.opened[i]
+		if entity.Type != entityType {
+			continue
+		}
+		builder.opened = append(builder.opened[:i], builder.opened[i+1:]...)
+		entity.Length = builder.length - entity.Offset
+		if entity.Length > 0 {
+			builder.entities = append(builder.entities, entity)
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%s is closed, but it hasn't been opened", entityType))
+}
+
+// toggle closes the entity of the type, if it's opened, opens it otherwise
+func (builder *entitiesBuilder) toggle(entityType tele.EntityType) {
+	if builder.innermost(entityType) != nil {
+		_ = builder.close(entityType)
+	} else {
+		builder.open(tele.MessageEntity{Type: entityType})
+	}
+}
+
+// trimNewline drops the newline right before the end of the code block, which is a part of the markup
+func (builder *entitiesBuilder) trimNewline() {
+	pre := builder.innermost(tele.EntityCodeBlock)
+	if pre != nil && builder.length > pre.Offset && builder.text[len(builder.text)-1] == '\n' {
+		builder.text = builder.text[:len(builder.text)-1]
+		builder.length--
+	}
+}
+
+func (builder *entitiesBuilder) result() (FormattedText, error) {
+	if len(builder.opened) > 0 {
+		return FormattedText{}, errors.New(fmt.Sprintf("%s at %d isn't closed", builder.opened[0].Type, builder.opened[0].Offset))
+	}
+	sort.SliceStable(builder.entities, func(i, j int) bool {
+		if builder.entities[i].Offset != builder.entities[j].Offset {
+			return builder.entities[i].Offset < builder.entities[j].Offset
+		}
+		return builder.entities[i].Length > builder.entities[j].Length
+	})
+	return FormattedText{Text: string(builder.text), Entities: builder.entities}, nil
+}
+
+// linkEntity is a text link, a mention of the user or a custom emoji, depending on the url
+func linkEntity(url string) tele.MessageEntity {
+	if strings.HasPrefix(url, tgUserLinkPrefix) {
+		id, err := strconv.ParseInt(url[len(tgUserLinkPrefix):], 10, 64)
+		if err == nil {
+			return tele.MessageEntity{Type: tele.EntityTMention, User: &tele.User{ID: id}}
+		}
+	}
+	if strings.HasPrefix(url, tgEmojiLinkPrefix) {
+		return tele.MessageEntity{Type: tele.EntityCustomEmoji, CustomEmoji: url[len(tgEmojiLinkPrefix):]}
+	}
+	return tele.MessageEntity{Type: tele.EntityTextLink, URL: url}
+}
+
+// openedQuote returns the opened quote, expandable or not, nil if there's none
+func (builder *entitiesBuilder) openedQuote() *tele.MessageEntity {
+	if quote := builder.innermost(tele.EntityBlockquote); quote != nil {
+		return quote
+	}
+	return builder.innermost(EntityExpandableBlockquote)
+}
+
+// closeLink closes the link opened by '[', the url is known only by now
+func (builder *entitiesBuilder) closeLink(url string) {
+	link := builder.innermost(tele.EntityTextLink)
+	offset := link.Offset
+	*link = linkEntity(url)
+	link.Offset = offset
+	_ = builder.close(link.Type)
+}
+
+// readLanguage reads the language of the code block from its first line, returns the number of runes read
+func readLanguage(runes []rune) (string, int) {
+	newline := -1
+	for i, char := range runes {
+		if char == '\n' {
+			newline = i
+			break
+		}
+		if unicode.IsSpace(char) || char == '`' {
+			return "", 0
+		}
+	}
+	if newline < 0 {
+		return "", 0
+	}
+	return string(runes[:newline]), newline + 1
+}
+
+func hasRunesPrefix(runes []rune, prefix string) bool {
+	for i, char := range []rune(prefix) {
+		if i >= len(runes) || runes[i] != char {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMarkdownV2 parses the markup, symbols, which are reserved but aren't a part of the markup, are taken as is
+func parseMarkdownV2(markdown string) (FormattedText, error) {
+	builder := entitiesBuilder{}
+	runes := []rune(markdown)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		insideCode := builder.innermost(tele.EntityCode) != nil || builder.innermost(tele.EntityCodeBlock) != nil
+		lineStart := i == 0 || runes[i-1] == '\n'
+		switch {
+		case char == '\\' && i+1 < len(runes):
+			i++
+			builder.write(string(runes[i]))
+		case char == '\r':
+			// separates ambiguous markers
+		case hasRunesPrefix(runes[i:], "```") && builder.innermost(tele.EntityCode) == nil:
+			i += 2
+			if builder.innermost(tele.EntityCodeBlock) != nil {
+				builder.trimNewline()
+				_ = builder.close(tele.EntityCodeBlock)
+			} else {
+				language, read := readLanguage(runes[i+1:])
+				i += read
+				builder.open(tele.MessageEntity{Type: tele.EntityCodeBlock, Language: language})
+			}
+		case char == '`' && builder.innermost(tele.EntityCodeBlock) == nil:
+			builder.toggle(tele.EntityCode)
+		case insideCode:
+			builder.write(string(char))
+		case lineStart && hasRunesPrefix(runes[i:], "**>") && builder.openedQuote() == nil:
+			i += 2
+			builder.open(tele.MessageEntity{Type: EntityExpandableBlockquote})
+		case lineStart && char == '>':
+			// every line of the quote starts with '>'
+			if builder.openedQuote() == nil {
+				builder.open(tele.MessageEntity{Type: tele.EntityBlockquote})
+			}
+		case char == '\n' && builder.openedQuote() != nil && !hasRunesPrefix(runes[i+1:], ">"):
+			_ = builder.close(builder.openedQuote().Type)
+			builder.write("\n")
+		case hasRunesPrefix(runes[i:], "||") && builder.innermost(EntityExpandableBlockquote) != nil &&
+			builder.innermost(tele.EntitySpoiler) == nil && (i+2 == len(runes) || runes[i+2] == '\n'):
+			// closes the expandable quote at the end of its last line
+			i++
+			_ = builder.close(EntityExpandableBlockquote)
+		case char == '*':
+			builder.toggle(tele.EntityBold)
+		case char == '~':
+			builder.toggle(tele.EntityStrikethrough)
+		case hasRunesPrefix(runes[i:], "||"):
+			i++
+			builder.toggle(tele.EntitySpoiler)
+		case hasRunesPrefix(runes[i:], "__"):
+			i++
+			builder.toggle(tele.EntityUnderline)
+		case char == '_':
+			builder.toggle(tele.EntityItalic)
+		case char == '[' || hasRunesPrefix(runes[i:], "!["):
+			// custom emoji are links to the emoji, which the url tells apart
+			if char == '!' {
+				i++
+			}
+			builder.open(tele.MessageEntity{Type: tele.EntityTextLink})
+		case hasRunesPrefix(runes[i:], "](") && builder.innermost(tele.EntityTextLink) != nil:
+			url := strings.Builder{}
+			for i += 2; i < len(runes) && runes[i] != ')'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				url.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return FormattedText{}, errors.New("url of the link isn't closed")
+			}
+			builder.closeLink(url.String())
+		default:
+			builder.write(string(char))
+		}
+	}
+	if quote := builder.openedQuote(); quote != nil {
+		_ = builder.close(quote.Type)
+	}
+	return builder.result()
+}
+
+// parseLegacyMarkdown parses the markup, entities can't be nested there and nothing is escaped inside them
+func parseLegacyMarkdown(markdown string) (FormattedText, error) {
+	builder := entitiesBuilder{}
+	runes := []rune(markdown)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		var inside *tele.MessageEntity
+		if len(builder.opened) > 0 {
+			inside = &builder.opened[0]
+		}
+		switch {
+		case inside == nil && char == '\\' && i+1 < len(runes) && strings.ContainsRune("_*`[", runes[i+1]):
+			i++
+			builder.write(string(runes[i]))
+		case hasRunesPrefix(runes[i:], "```") && (inside == nil || inside.Type == tele.EntityCodeBlock):
+			i += 2
+			if inside != nil {
+				builder.trimNewline()
+				_ = builder.close(tele.EntityCodeBlock)
+			} else {
+				language, read := readLanguage(runes[i+1:])
+				i += read
+				builder.open(tele.MessageEntity{Type: tele.EntityCodeBlock, Language: language})
+			}
+		case char == '*' && (inside == nil || inside.Type == tele.EntityBold):
+			builder.toggle(tele.EntityBold)
+		case char == '_' && (inside == nil || inside.Type == tele.EntityItalic):
+			builder.toggle(tele.EntityItalic)
+		case char == '`' && (inside == nil || inside.Type == tele.EntityCode):
+			builder.toggle(tele.EntityCode)
+		case char == '[' && inside == nil:
+			builder.open(tele.MessageEntity{Type: tele.EntityTextLink})
+		case hasRunesPrefix(runes[i:], "](") && inside != nil && inside.Type == tele.EntityTextLink:
+			end := i + 2
+			for end < len(runes) && runes[end] != ')' {
+				end++
+			}
+			if end == len(runes) {
+				return FormattedText{}, errors.New("url of the link isn't closed")
+			}
+			builder.closeLink(string(runes[i+2 : end]))
+			i = end
+		default:
+			builder.write(string(char))
+		}
+	}
+	return builder.result()
+}
+
+var htmlAttributeRegexp = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"']+))`)
+
+var htmlTagEntities = map[string]tele.EntityType{
+	"b":          tele.EntityBold,
+	"strong":     tele.EntityBold,
+	"i":          tele.EntityItalic,
+	"em":         tele.EntityItalic,
+	"u":          tele.EntityUnderline,
+	"ins":        tele.EntityUnderline,
+	"s":          tele.EntityStrikethrough,
+	"strike":     tele.EntityStrikethrough,
+	"del":        tele.EntityStrikethrough,
+	"tg-spoiler": tele.EntitySpoiler,
+	"code":       tele.EntityCode,
+	"pre":        tele.EntityCodeBlock,
+	"a":          tele.EntityTextLink,
+	"tg-emoji":   tele.EntityCustomEmoji,
+	"blockquote": tele.EntityBlockquote,
+}
+
+var htmlExpandableRegexp = regexp.MustCompile(`(?i)\bexpandable\b`)
+
+// parseHTML parses the subset of HTML supported by Telegram
+func parseHTML(markup string) (FormattedText, error) {
+	builder := entitiesBuilder{}
+	codeOfPre := false // <code class="language-..."> right inside <pre> sets the language of the code block
+	for markup != "" {
+		tagStart := strings.IndexByte(markup, '<')
+		if tagStart < 0 {
+			builder.write(html.UnescapeString(markup))
+			break
+		}
+		builder.write(html.UnescapeString(markup[:tagStart]))
+		tagEnd := strings.IndexByte(markup[tagStart:], '>')
+		if tagEnd < 0 {
+			return FormattedText{}, errors.New(fmt.Sprintf("tag at %d isn't closed", tagStart))
+		}
+		tag := strings.TrimSpace(markup[tagStart+1 : tagStart+tagEnd])
+		markup = markup[tagStart+tagEnd+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		tag = strings.TrimPrefix(tag, "/")
+		name, attributesText := tag, ""
+		if space := strings.IndexFunc(tag, unicode.IsSpace); space >= 0 {
+			name, attributesText = tag[:space], tag[space:]
+		}
+		name = strings.ToLower(name)
+		attributes := map[string]string{}
+		for _, match := range htmlAttributeRegexp.FindAllStringSubmatch(attributesText, -1) {
+			attributes[strings.ToLower(match[1])] = html.UnescapeString(match[2] + match[3] + match[4])
+		}
+
+		entityType, supported := htmlTagEntities[name]
+		if name == "span" && (closing || attributes["class"] == "tg-spoiler") {
+			entityType, supported = tele.EntitySpoiler, true
+		}
+		if !supported {
+			return FormattedText{}, errors.New(fmt.Sprintf("tag <%s> isn't supported", name))
+		}
+
+		pre := builder.innermost(tele.EntityCodeBlock)
+		switch {
+		case closing && entityType == tele.EntityCode && codeOfPre:
+			codeOfPre = false
+		case closing:
+			if entityType == tele.EntityTextLink && builder.innermost(tele.EntityTMention) != nil {
+				entityType = tele.EntityTMention
+			}
+			if entityType == tele.EntityBlockquote && builder.openedQuote() != nil {
+				entityType = builder.openedQuote().Type
+			}
+			err := builder.close(entityType)
+			if err != nil {
+				return FormattedText{}, err
+			}
+		case entityType == tele.EntityCode && pre != nil && pre.Offset == builder.length && len(builder.opened) > 0 &&
+			builder.opened[len(builder.opened)-1].Type == tele.EntityCodeBlock:
+			codeOfPre = true
+			pre.Language = strings.TrimPrefix(attributes["class"], "language-")
+		case entityType == tele.EntityTextLink:
+			builder.open(linkEntity(attributes["href"]))
+		case entityType == tele.EntityCustomEmoji:
+			builder.open(tele.MessageEntity{Type: tele.EntityCustomEmoji, CustomEmoji: attributes["emoji-id"]})
+		case entityType == tele.EntityBlockquote && htmlExpandableRegexp.MatchString(attributesText):
+			builder.open(tele.MessageEntity{Type: EntityExpandableBlockquote})
+		default:
+			builder.open(tele.MessageEntity{Type: entityType})
+		}
+	}
+	return builder.result()
+}
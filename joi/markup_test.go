@@ -0,0 +1,115 @@
+package joi
+
+import (
+	tele "gopkg.in/telebot.v3"
+	"testing"
+)
+
+func TestParseMarkup(t *testing.T) {
+	for _, test := range []struct {
+		markup    string
+		parseMode string
+		text      string
+		entities  string
+	}{
+		{"plain \\. text\\!", tele.ModeMarkdownV2, "plain . text!", "[]"},
+		{"🔥 *bold _italic_*", tele.ModeMarkdownV2, "🔥 bold italic", "[bold 3 11 italic 8 6]"},
+		{"__under__\r_italic_ ||spoiler|| ~strike~", tele.ModeMarkdownV2, "underitalic spoiler strike", "[underline 0 5 italic 5 6 spoiler 12 7 strikethrough 20 6]"},
+		{"[link \\[1\\]](https://example.com/a_(b\\))", tele.ModeMarkdownV2, "link [1]", "[text_link 0 8]"},
+		{"`a*b` ```go\nfmt.Println(\\`hi\\`)\n```", tele.ModeMarkdownV2, "a*b fmt.Println(`hi`)", "[code 0 3 pre 4 17]"},
+		{"*not closed", tele.ModeMarkdownV2, "", "error"},
+		{"*bold* _it_ \\_ `code` [link](https://example.com)", tele.ModeMarkdown, "bold it _ code link", "[bold 0 4 italic 5 2 code 10 4 text_link 15 4]"},
+		{"_snake_\\__case_", tele.ModeMarkdown, "snake_case", "[italic 0 5 italic 6 4]"},
+		{"<b>bold <i>both</i></b> &lt;tag&gt; <a href=\"https://example.com?a=1&amp;b=2\">link</a>", tele.ModeHTML, "bold both <tag> link", "[bold 0 9 italic 5 4 text_link 16 4]"},
+		{"<span class=\"tg-spoiler\">s</span> <pre><code class=\"language-go\">x</code></pre>", tele.ModeHTML, "s x", "[spoiler 0 1 pre 2 1]"},
+		{"<b>not closed", tele.ModeHTML, "", "error"},
+		{"<marquee>no</marquee>", tele.ModeHTML, "", "error"},
+		{"said:\n>first\n>*second*\nafter", tele.ModeMarkdownV2, "said:\nfirst\nsecond\nafter", "[blockquote 6 12 bold 12 6]"},
+		{"**>long\n>quote||\nafter ||spoiler||", tele.ModeMarkdownV2, "long\nquote\nafter spoiler", "[expandable_blockquote 0 10 spoiler 17 7]"},
+		{"![👍](tg://emoji?id=42) ok", tele.ModeMarkdownV2, "👍 ok", "[custom_emoji 0 2]"},
+		{"<blockquote expandable>q</blockquote><blockquote>r</blockquote><tg-emoji emoji-id=\"42\">👍</tg-emoji>", tele.ModeHTML, "qr👍", "[expandable_blockquote 0 1 blockquote 1 1 custom_emoji 2 2]"},
+		{"*as is*", ModeEntities, "*as is*", "[]"},
+	} {
+		formatted, err := parseMarkup(test.markup, test.parseMode)
+		if test.entities == "error" {
+			if err == nil {
+				t.Errorf("%s '%s' is expected to fail", test.parseMode, test.markup)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s '%s' failed: %s", test.parseMode, test.markup, err.Error())
+		} else if formatted.Text != test.text || formatTestEntities(formatted.Entities) != test.entities {
+			t.Errorf("%s '%s' is parsed into '%s' %s, expected '%s' %s", test.parseMode, test.markup,
+				formatted.Text, formatTestEntities(formatted.Entities), test.text, test.entities)
+		}
+	}
+
+	formatted, _ := parseMarkup("<a href=\"tg://user?id=42\">you</a> [me](tg://user?id=7)", tele.ModeHTML)
+	if len(formatted.Entities) != 1 || formatted.Entities[0].Type != tele.EntityTMention || formatted.Entities[0].User.ID != 42 {
+		t.Errorf("mention is parsed into %+v", formatted.Entities)
+	}
+}
+
+func TestRenderText_AllEntities(t *testing.T) {
+	text := "👍 bold italic under strike spoiler code pre link mention\nquote\nhidden\n#tag $TAG @user /cmd https://t.me a@b.c +100"
+	entities := tele.Entities{
+		{Type: tele.EntityCustomEmoji, Offset: 0, Length: 2, CustomEmoji: "42"},
+		{Type: tele.EntityBold, Offset: 3, Length: 4},
+		{Type: tele.EntityItalic, Offset: 8, Length: 6},
+		{Type: tele.EntityUnderline, Offset: 15, Length: 5},
+		{Type: tele.EntityStrikethrough, Offset: 21, Length: 6},
+		{Type: tele.EntitySpoiler, Offset: 28, Length: 7},
+		{Type: tele.EntityCode, Offset: 36, Length: 4},
+		{Type: tele.EntityCodeBlock, Offset: 41, Length: 3, Language: "go"},
+		{Type: tele.EntityTextLink, Offset: 45, Length: 4, URL: "https://example.com"},
+		{Type: tele.EntityTMention, Offset: 50, Length: 7, User: &tele.User{ID: 7}},
+		{Type: tele.EntityBlockquote, Offset: 58, Length: 5},
+		{Type: EntityExpandableBlockquote, Offset: 64, Length: 6},
+	}
+	for _, parseMode := range []string{tele.ModeMarkdownV2, tele.ModeHTML} {
+		rendered := renderText(text, entities, parseMode)
+		formatted, err := parseMarkup(rendered, parseMode)
+		if err != nil {
+			t.Errorf("%s '%s' failed: %s", parseMode, rendered, err.Error())
+			continue
+		}
+		if formatted.Text != text || formatTestEntities(formatted.Entities) != formatTestEntities(entities) {
+			t.Errorf("%s '%s' is parsed back into '%s' %s", parseMode, rendered, formatted.Text, formatTestEntities(formatted.Entities))
+		}
+		if formatted.Entities[0].CustomEmoji != "42" || formatted.Entities[7].Language != "go" || formatted.Entities[9].User.ID != 7 {
+			t.Errorf("%s '%s' lost details of the entities: %+v", parseMode, rendered, formatted.Entities)
+		}
+	}
+}
+
+func TestRenderText_RoundTrip(t *testing.T) {
+	text := "🔥 日本語 bold, *italic*, link & <code>\\"
+	entities := tele.Entities{
+		{Type: tele.EntityBold, Offset: 7, Length: 4},
+		{Type: tele.EntityItalic, Offset: 13, Length: 8},
+		{Type: tele.EntityTextLink, Offset: 23, Length: 4, URL: "https://example.com/(a)"},
+		{Type: tele.EntityCode, Offset: 30, Length: 6},
+	}
+	for _, parseMode := range []string{tele.ModeMarkdownV2, tele.ModeMarkdown, tele.ModeHTML} {
+		rendered := renderText(text, entities, parseMode)
+		formatted, err := parseMarkup(rendered, parseMode)
+		if err != nil {
+			t.Errorf("%s '%s' failed: %s", parseMode, rendered, err.Error())
+			continue
+		}
+		if formatted.Text != text {
+			t.Errorf("%s '%s' is parsed back into '%s'", parseMode, rendered, formatted.Text)
+		}
+		if parseMode != tele.ModeMarkdown && formatTestEntities(formatted.Entities) != formatTestEntities(entities) {
+			t.Errorf("%s '%s' is parsed back into %s", parseMode, rendered, formatTestEntities(formatted.Entities))
+		}
+	}
+
+	if rendered := renderText(text, tele.Entities{{Type: tele.EntityUnderline, Offset: 0, Length: 2}}, tele.ModeMarkdown); rendered != "🔥 日本語 bold, \\*italic\\*, link & <code>\\" {
+		t.Errorf("underline isn't dropped for legacy markdown: '%s'", rendered)
+	}
+	if rendered := renderText(text, entities, ModeEntities); rendered != text {
+		t.Errorf("text is changed for native entities: '%s'", rendered)
+	}
+}
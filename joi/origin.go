@@ -0,0 +1,120 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"html"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	AttributionToText    = "text"
+	AttributionToComment = "comment"
+)
+
+// PostOrigin is the channel, the post was forwarded from
+type PostOrigin struct {
+	Title     string `json:"title"`
+	Username  string `json:"username,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Link      string `json:"link,omitempty"` // empty if the message id is unknown
+}
+
+// postOriginOf returns the channel, the message was forwarded from, nil if it wasn't forwarded from a channel
+func postOriginOf(msg *tele.Message) *PostOrigin {
+	if msg.OriginalChat == nil || msg.OriginalChat.Type != tele.ChatChannel {
+		return nil
+	}
+
+	origin := &PostOrigin{
+		Title:     msg.OriginalChat.Title,
+		Username:  msg.OriginalChat.Username,
+		Signature: msg.OriginalSignature,
+	}
+	if msg.OriginalMessageID != 0 {
+		origin.Link = messageLink(msg.OriginalChat, msg.OriginalMessageID)
+	}
+	return origin
+}
+
+func messageLink(chat *tele.Chat, msgId int) string {
+	if chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, msgId)
+	}
+	// private channels are linked by the id without -100 prefix
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(strconv.FormatInt(chat.ID, 10), "-100"), msgId)
+}
+
+// chatLink links the chat by the username, private ones by the invite link, if the bot knows it
+func chatLink(chat *tele.Chat) string {
+	if chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s", chat.Username)
+	}
+	if chat.InviteLink != "" {
+		return chat.InviteLink
+	}
+	return fmt.Sprintf("https://t.me/c/%s", strings.TrimPrefix(strconv.FormatInt(chat.ID, 10), "-100"))
+}
+
+// renderAttribution fills the template with the origin, values are escaped for the parse mode,
+// since the result becomes a part of the text of the post
+func renderAttribution(attributionTemplate string, origin *PostOrigin, parseMode string) (string, error) {
+	tmpl, err := template.New("attribution").Parse(attributionTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	escaped := *origin
+	for _, field := range []*string{&escaped.Title, &escaped.Username, &escaped.Signature, &escaped.Link} {
+		*field = escapeForParseMode(*field, parseMode)
+	}
+
+	attribution := strings.Builder{}
+	err = tmpl.Execute(&attribution, escaped)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(attribution.String()), nil
+}
+
+func escapeForParseMode(text string, parseMode string) string {
+	switch parseMode {
+	case tele.ModeMarkdownV2:
+		return escapeTgMarkdownV2SpecialSymbols(text)
+	case tele.ModeMarkdown:
+		return strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[").Replace(text)
+	case tele.ModeHTML:
+		return html.EscapeString(text)
+	default:
+		return text
+	}
+}
+
+// attribute remembers the origin of the forwarded message and adds the attribution to the text or the comment of the post
+func (joi *Joi) attribute(base *PostInfo, msg *tele.Message) error {
+	origin := postOriginOf(msg)
+	if origin == nil || msg.OriginalChat.ID == joi.Cfg.ChannelId {
+		return nil
+	}
+	base.Origin = origin
+
+	attribution, err := renderAttribution(joi.Cfg.AttributionTemplate, origin, joi.Cfg.ParseMode)
+	if err != nil || attribution == "" {
+		return err
+	}
+	parsed, err := parseMarkup(attribution, joi.Cfg.ParseMode)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s\nfor attribution '%s'", err.Error(), attribution))
+	}
+	if joi.Cfg.AttributionTarget == AttributionToComment {
+		comment := joinText(FormattedText{Text: base.Comment, Entities: base.CommentEntities}, parsed, "\n\n")
+		base.Comment, base.CommentEntities = comment.Text, comment.Entities
+	} else {
+		text := joinText(FormattedText{Text: base.Text, Entities: base.TextEntities}, parsed, "\n\n")
+		base.Text, base.TextEntities = text.Text, text.Entities
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package joi
+
+import (
+	tele "gopkg.in/telebot.v3"
+	"testing"
+)
+
+func TestPostOriginOf(t *testing.T) {
+	for _, test := range []struct {
+		msg      *tele.Message
+		expected *PostOrigin
+	}{
+		{&tele.Message{Text: "not forwarded"}, nil},
+		{&tele.Message{OriginalSender: &tele.User{ID: 1}}, nil},
+		{
+			&tele.Message{OriginalChat: &tele.Chat{ID: -1001234, Type: tele.ChatChannel, Title: "Partner", Username: "partner"}, OriginalMessageID: 42},
+			&PostOrigin{Title: "Partner", Username: "partner", Link: "https://t.me/partner/42"},
+		},
+		{
+			&tele.Message{OriginalChat: &tele.Chat{ID: -1001234, Type: tele.ChatChannel, Title: "Private"}, OriginalMessageID: 7, OriginalSignature: "admin"},
+			&PostOrigin{Title: "Private", Signature: "admin", Link: "https://t.me/c/1234/7"},
+		},
+	} {
+		origin := postOriginOf(test.msg)
+		if (origin == nil) != (test.expected == nil) || origin != nil && *origin != *test.expected {
+			t.Errorf("origin is %+v, expected %+v", origin, test.expected)
+		}
+	}
+}
+
+func TestRenderAttribution(t *testing.T) {
+	origin := &PostOrigin{Title: "Art (daily)", Username: "art_daily", Link: "https://t.me/art_daily/1"}
+	for _, test := range []struct {
+		template  string
+		parseMode string
+		expected  string
+	}{
+		{DefaultAttributionTemplate, tele.ModeMarkdownV2, "via @art\\_daily"},
+		{DefaultAttributionTemplate, "", "via @art_daily"},
+		{"via {{.Title}}", tele.ModeHTML, "via Art (daily)"},
+		{"via [{{.Title}}]({{.Link}})", tele.ModeMarkdownV2, "via [Art \\(daily\\)](https://t\\.me/art\\_daily/1)"},
+		{"{{if .Signature}}by {{.Signature}}{{end}}", tele.ModeMarkdownV2, ""},
+	} {
+		attribution, err := renderAttribution(test.template, origin, test.parseMode)
+		if err != nil {
+			t.Errorf("'%s' failed: %s", test.template, err.Error())
+		} else if attribution != test.expected {
+			t.Errorf("'%s' is rendered to '%s', expected '%s'", test.template, attribution, test.expected)
+		}
+	}
+
+	_, err := renderAttribution("via {{.Title", origin, "")
+	if err == nil {
+		t.Errorf("broken template is expected to fail")
+	}
+}
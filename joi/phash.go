@@ -0,0 +1,105 @@
+package joi
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+const DefaultDuplicateHashDistance = 5
+
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+	// every cell of the shrunk image is averaged over at most dHashSamples x dHashSamples pixels
+	dHashSamples = 16
+)
+
+// ImageHash computes the perceptual hash of the image, formats Go can't decode are converted into png first
+func (converter *Converter) ImageHash(ctx context.Context, filename string) (uint64, error) {
+	format, err := DetectImageFormat(filename)
+	if err != nil {
+		return 0, err
+	}
+	if format != ImageFormatJpeg && format != ImageFormatPng && format != ImageFormatGif {
+		decoded, err := converter.Decode(ctx, filename, format)
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(decoded)
+		filename = decoded
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img), nil
+}
+
+// dHash shrinks the image to 9x8 grayscale cells, every bit of the hash tells whether a cell is brighter
+// than its right neighbour, so the hash survives resizing, recompression and slight color changes
+func dHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	var cells [dHashHeight][dHashWidth]float64
+	for y := 0; y < dHashHeight; y++ {
+		y0, y1 := cellBounds(bounds.Min.Y, bounds.Dy(), y, dHashHeight)
+		for x := 0; x < dHashWidth; x++ {
+			x0, x1 := cellBounds(bounds.Min.X, bounds.Dx(), x, dHashWidth)
+			stepX, stepY := (x1-x0)/dHashSamples+1, (y1-y0)/dHashSamples+1
+
+			sum, n := 0.0, 0
+			for py := y0; py < y1; py += stepY {
+				for px := x0; px < x1; px += stepX {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					n++
+				}
+			}
+			cells[y][x] = sum / float64(n)
+		}
+	}
+
+	hash := uint64(0)
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x+1 < dHashWidth; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// cellBounds returns pixels range of the i-th of n cells, every cell has at least one pixel
+func cellBounds(start int, length int, i int, n int) (int, int) {
+	from, to := start+i*length/n, start+(i+1)*length/n
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+func hashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parseHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
@@ -0,0 +1,71 @@
+package joi
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path"
+	"testing"
+)
+
+// testPicture draws diagonal stripes, mirrored ones if flipped
+func testPicture(width int, height int, flipped bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := x*100/width, y*100/height
+			if flipped {
+				fx = 100 - fx
+			}
+			value := uint8((fx*3 + fy*2) % 100 * 255 / 100)
+			img.Set(x, y, color.RGBA{R: value, G: value / 2, B: 255 - value, A: 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	original := dHash(testPicture(800, 600, false))
+	resized := dHash(testPicture(400, 300, false))
+	different := dHash(testPicture(800, 600, true))
+
+	if distance := hashDistance(original, resized); distance > DefaultDuplicateHashDistance {
+		t.Errorf("resized picture is %d away from the original", distance)
+	}
+	if distance := hashDistance(original, different); distance <= DefaultDuplicateHashDistance {
+		t.Errorf("different picture is only %d away from the original", distance)
+	}
+
+	parsed, err := parseHash(formatHash(original))
+	if err != nil || parsed != original {
+		t.Errorf("hash %x is parsed back as %x, %v", original, parsed, err)
+	}
+}
+
+func TestConverter_ImageHash(t *testing.T) {
+	filename := path.Join(t.TempDir(), "recompressed.jpg")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jpeg.Encode(file, testPicture(640, 480, false), &jpeg.Options{Quality: 40})
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := NewConverter().ImageHash(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance := hashDistance(hash, dHash(testPicture(640, 480, false))); distance > DefaultDuplicateHashDistance {
+		t.Errorf("recompressed picture is %d away from the original", distance)
+	}
+
+	_, err = NewConverter().ImageHash(context.Background(), path.Join("testdata", "sample.png"))
+	if err != nil {
+		t.Errorf("png isn't hashed: %s", err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TelegramMinimumPollOptions     = 2
+	TelegramMaximumPollOptions     = 10
+	TelegramMaximumPollQuestion    = 300
+	TelegramMaximumPollOption      = 100
+	TelegramMaximumQuizExplanation = 200
+)
+
+// PollInfo is a poll or a quiz, stored with the post as json
+type PollInfo struct {
+	Question        string        `json:"question"`
+	Options         []string      `json:"options"`
+	Anonymous       bool          `json:"anonymous"`
+	MultipleAnswers bool          `json:"multiple_answers,omitempty"`
+	Quiz            bool          `json:"quiz,omitempty"`
+	CorrectOption   int           `json:"correct_option,omitempty"`
+	Explanation     string        `json:"explanation,omitempty"`
+	CloseAfter      time.Duration `json:"close_after,omitempty"` // Joi stops the poll after it is posted, 0 - never
+}
+
+func NewPollInfo() *PollInfo {
+	return &PollInfo{Anonymous: true}
+}
+
+func pollInfoFromTelegram(poll *tele.Poll) *PollInfo {
+	info := &PollInfo{
+		Question:        poll.Question,
+		Options:         make([]string, len(poll.Options)),
+		Anonymous:       poll.Anonymous,
+		MultipleAnswers: poll.MultipleAnswers,
+		Quiz:            poll.IsQuiz(),
+		CorrectOption:   poll.CorrectOption,
+		Explanation:     poll.Explanation,
+	}
+	for i, option := range poll.Options {
+		info.Options[i] = option.Text
+	}
+	return info
+}
+
+func (poll *PollInfo) ToTelegram() *tele.Poll {
+	tgPoll := &tele.Poll{
+		Type:            tele.PollRegular,
+		Question:        poll.Question,
+		Anonymous:       poll.Anonymous,
+		MultipleAnswers: poll.MultipleAnswers,
+	}
+	if poll.Quiz {
+		tgPoll.Type = tele.PollQuiz
+		tgPoll.CorrectOption = poll.CorrectOption
+		tgPoll.Explanation = poll.Explanation
+	}
+	tgPoll.AddOptions(poll.Options...)
+	return tgPoll
+}
+
+// ValidateQuestion checks the question alone, so the poll wizard rejects it before the options are asked
+func (poll *PollInfo) ValidateQuestion() error {
+	switch {
+	case strings.TrimSpace(poll.Question) == "":
+		return errors.New("question of the poll is empty")
+	case utf16Length(poll.Question) > TelegramMaximumPollQuestion:
+		return errors.New(fmt.Sprintf("question of the poll is %d characters long (max - %d)",
+			utf16Length(poll.Question), TelegramMaximumPollQuestion))
+	}
+	return nil
+}
+
+func (poll *PollInfo) Validate() error {
+	if err := poll.ValidateQuestion(); err != nil {
+		return err
+	}
+	switch {
+	case len(poll.Options) < TelegramMinimumPollOptions || len(poll.Options) > TelegramMaximumPollOptions:
+		return errors.New(fmt.Sprintf("poll must have from %d to %d options, %d is given",
+			TelegramMinimumPollOptions, TelegramMaximumPollOptions, len(poll.Options)))
+	case poll.Quiz && poll.MultipleAnswers:
+		return errors.New("quiz can't have multiple answers")
+	case poll.Quiz && (poll.CorrectOption < 0 || poll.CorrectOption >= len(poll.Options)):
+		return errors.New(fmt.Sprintf("correct option %d of the quiz doesn't exist", poll.CorrectOption+1))
+	case utf16Length(poll.Explanation) > TelegramMaximumQuizExplanation:
+		return errors.New(fmt.Sprintf("explanation of the quiz is too long (max - %d)", TelegramMaximumQuizExplanation))
+	case poll.CloseAfter < 0:
+		return errors.New("close time of the poll is negative")
+	}
+	for i, option := range poll.Options {
+		if strings.TrimSpace(option) == "" || utf16Length(option) > TelegramMaximumPollOption {
+			return errors.New(fmt.Sprintf("option %d must be from 1 to %d characters long", i+1, TelegramMaximumPollOption))
+		}
+	}
+	return nil
+}
+
+// parsePollSettings applies space separated settings to the poll:
+// anonymous/public, multiple, quiz <number of the correct option>, close <duration, i.e. 24h>, or '-' for none of them
+func parsePollSettings(poll *PollInfo, settings string) error {
+	args := strings.Fields(settings)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "-":
+		case "anonymous":
+			poll.Anonymous = true
+		case "public":
+			poll.Anonymous = false
+		case "multiple":
+			poll.MultipleAnswers = true
+		case "quiz":
+			if i+1 == len(args) {
+				return errors.New("number of the correct option must follow 'quiz'")
+			}
+			i++
+			correct, err := strconv.Atoi(args[i])
+			if err != nil {
+				return errors.New(fmt.Sprintf("'%s' is not a number of the correct option", args[i]))
+			}
+			poll.Quiz = true
+			poll.CorrectOption = correct - 1
+		case "close":
+			if i+1 == len(args) {
+				return errors.New("duration must follow 'close', i.e. 'close 24h'")
+			}
+			i++
+			closeAfter, err := time.ParseDuration(args[i])
+			if err != nil {
+				return err
+			}
+			poll.CloseAfter = closeAfter
+		default:
+			return errors.New(fmt.Sprintf("unknown setting '%s'", args[i]))
+		}
+	}
+	return poll.Validate()
+}
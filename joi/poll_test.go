@@ -0,0 +1,60 @@
+package joi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePollSettings(t *testing.T) {
+	newPoll := func() *PollInfo {
+		poll := NewPollInfo()
+		poll.Question = "which one?"
+		poll.Options = []string{"first", "second", "third"}
+		return poll
+	}
+
+	for _, test := range []struct {
+		settings string
+		expected PollInfo
+		fails    bool
+	}{
+		{settings: "-", expected: PollInfo{Anonymous: true}},
+		{settings: "public multiple", expected: PollInfo{MultipleAnswers: true}},
+		{settings: "quiz 2 close 24h", expected: PollInfo{Anonymous: true, Quiz: true, CorrectOption: 1, CloseAfter: 24 * time.Hour}},
+		{settings: "quiz 4", fails: true},
+		{settings: "quiz 1 multiple", fails: true},
+		{settings: "close", fails: true},
+		{settings: "close tomorrow", fails: true},
+		{settings: "secret", fails: true},
+	} {
+		poll := newPoll()
+		err := parsePollSettings(poll, test.settings)
+		if test.fails {
+			if err == nil {
+				t.Errorf("settings '%s' are expected to fail", test.settings)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("settings '%s' failed: %s", test.settings, err.Error())
+			continue
+		}
+		if poll.Anonymous != test.expected.Anonymous || poll.MultipleAnswers != test.expected.MultipleAnswers ||
+			poll.Quiz != test.expected.Quiz || poll.CorrectOption != test.expected.CorrectOption || poll.CloseAfter != test.expected.CloseAfter {
+			t.Errorf("settings '%s' resulted in %+v, expected %+v", test.settings, *poll, test.expected)
+		}
+	}
+}
+
+func TestPollInfo_ToTelegram(t *testing.T) {
+	poll := &PollInfo{Question: "2+2?", Options: []string{"4", "5"}, Quiz: true, CorrectOption: 0, Explanation: "math"}
+	tgPoll := poll.ToTelegram()
+	if !tgPoll.IsQuiz() || len(tgPoll.Options) != 2 || tgPoll.Explanation != "math" || tgPoll.Anonymous {
+		t.Errorf("%+v is converted wrong to %+v", *poll, *tgPoll)
+	}
+
+	back := pollInfoFromTelegram(tgPoll)
+	if back.Question != poll.Question || len(back.Options) != 2 || back.Options[1] != "5" || !back.Quiz {
+		t.Errorf("%+v is converted back wrong to %+v", *tgPoll, *back)
+	}
+}
@@ -0,0 +1,169 @@
+package joi
+
+import (
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pollWizardQuestion = iota
+	pollWizardOptions
+	pollWizardSettings
+)
+
+// pollWizardTimeout is how long the wizard waits for the next answer, afterwards the admin's messages are handled as usual
+const pollWizardTimeout = 10 * time.Minute
+
+// pollWizard collects a poll from the admin's messages step by step, started with /poll
+type pollWizard struct {
+	step    int
+	command *tele.Message // the post is linked to it
+	prompt  *tele.Message // the last question of the wizard
+	poll    *PollInfo
+	expires time.Time
+}
+
+// waitsFor tells whether the message is the answer, messages replying to anything but the wizard's ones aren't
+func (wizard *pollWizard) waitsFor(msg *tele.Message) bool {
+	return isInputFor(msg, wizard.command, wizard.prompt)
+}
+
+type pollWizards struct {
+	mutex   sync.Mutex
+	wizards map[int64]*pollWizard // admin id -> wizard in progress
+}
+
+// get returns a copy of the admin's wizard, so it's changed only by update, the expired one is forgotten
+func (wizards *pollWizards) get(adminId int64) *pollWizard {
+	defer wizards.mutex.Unlock()
+	wizards.mutex.Lock()
+	wizard := wizards.wizards[adminId]
+	if wizard == nil {
+		return nil
+	}
+	if time.Now().After(wizard.expires) {
+		delete(wizards.wizards, adminId)
+		return nil
+	}
+	copied, poll := *wizard, *wizard.poll
+	copied.poll = &poll
+	return &copied
+}
+
+// update saves the changed wizard, unless it's cancelled meanwhile
+func (wizards *pollWizards) update(adminId int64, wizard *pollWizard) {
+	defer wizards.mutex.Unlock()
+	wizards.mutex.Lock()
+	if _, ok := wizards.wizards[adminId]; ok {
+		wizards.wizards[adminId] = wizard
+	}
+}
+
+func (wizards *pollWizards) set(adminId int64, wizard *pollWizard) {
+	defer wizards.mutex.Unlock()
+	wizards.mutex.Lock()
+	if wizard == nil {
+		delete(wizards.wizards, adminId)
+	} else {
+		wizards.wizards[adminId] = wizard
+	}
+}
+
+func (joi *Joi) startPollWizard(ctx tele.Context) error {
+	prompt, err := joi.Bot.Reply(ctx.Message(), "send the question of the poll (/cancel to stop)")
+	if err != nil {
+		return err
+	}
+	joi.pollWizards.set(ctx.Sender().ID, &pollWizard{
+		step:    pollWizardQuestion,
+		command: ctx.Message(),
+		prompt:  prompt,
+		poll:    NewPollInfo(),
+		expires: time.Now().Add(pollWizardTimeout),
+	})
+	return nil
+}
+
+// continuePollWizard takes the admin's answer, the wizard is a copy, which is saved once the next question is asked
+func (joi *Joi) continuePollWizard(ctx tele.Context, wizard *pollWizard) error {
+	ask := func(question string) error {
+		prompt, err := joi.Bot.Reply(ctx.Message(), question)
+		if err != nil {
+			return err
+		}
+		wizard.prompt = prompt
+		wizard.expires = time.Now().Add(pollWizardTimeout)
+		joi.pollWizards.update(ctx.Sender().ID, wizard)
+		return nil
+	}
+
+	text := strings.TrimSpace(ctx.Message().Text)
+	switch wizard.step {
+	case pollWizardQuestion:
+		wizard.poll.Question = text
+		err := wizard.poll.ValidateQuestion()
+		if err != nil {
+			return ask(err.Error() + ", send the question again")
+		}
+		wizard.step = pollWizardOptions
+		return ask("send the options, one per line")
+	case pollWizardOptions:
+		wizard.poll.Options = make([]string, 0)
+		for _, option := range strings.Split(text, "\n") {
+			if option = strings.TrimSpace(option); option != "" {
+				wizard.poll.Options = append(wizard.poll.Options, option)
+			}
+		}
+		err := wizard.poll.Validate()
+		if err != nil {
+			return ask(err.Error() + ", send the options again")
+		}
+		wizard.step = pollWizardSettings
+		return ask("send the settings separated by spaces or '-' to keep the defaults (anonymous, single answer, never closed):\n" +
+			"anonymous/public, multiple, quiz <number of the correct option>, close <duration, i.e. 24h>")
+	default:
+		err := parsePollSettings(wizard.poll, text)
+		if err != nil {
+			return ask(err.Error() + ", send the settings again")
+		}
+		joi.pollWizards.set(ctx.Sender().ID, nil)
+		_, err = joi.addPost(&PostInfo{
+			Poll:    wizard.poll,
+			Buttons: joi.Cfg.DefaultButtons,
+		}, wizard.command)
+		if err != nil {
+			return err
+		}
+		joi.sendExpiring(time.Second*30, ctx.Chat(), "+", &tele.SendOptions{ReplyTo: wizard.command})
+		return nil
+	}
+}
+
+func (joi *Joi) cancelPollWizard(ctx tele.Context) error {
+	if joi.pollWizards.get(ctx.Sender().ID) == nil {
+		return ctx.Reply("nothing to cancel")
+	}
+	joi.pollWizards.set(ctx.Sender().ID, nil)
+	return ctx.Reply("cancelled.")
+}
+
+// addPollPost adds a post from the poll forwarded by the admin
+func (joi *Joi) addPollPost(ctx tele.Context) error {
+	base := &PostInfo{Buttons: joi.Cfg.DefaultButtons}
+	err := joi.attribute(base, ctx.Message())
+	if err != nil {
+		return err
+	}
+	post, err := joi.addPost(base, ctx.Message())
+	if err != nil {
+		return err
+	}
+	if post.Poll.Quiz {
+		return ctx.Reply(fmt.Sprintf("+, the correct option is %d", post.Poll.CorrectOption+1))
+	}
+	joi.sendExpiring(time.Second*30, ctx.Chat(), "+", &tele.SendOptions{ReplyTo: ctx.Message()})
+	return nil
+}
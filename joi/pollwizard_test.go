@@ -0,0 +1,133 @@
+package joi
+
+import (
+	"encoding/json"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBot returns the bot, which talks to a fake Telegram, and a function returning texts of the sent messages
+func newTestBot(t *testing.T) (*tele.Bot, func() []string) {
+	mutex := sync.Mutex{}
+	sent := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		if text, ok := params["text"].(string); ok {
+			mutex.Lock()
+			sent = append(sent, text)
+			mutex.Unlock()
+		}
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1},"date":0}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := tele.NewBot(tele.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, func() []string {
+		defer mutex.Unlock()
+		mutex.Lock()
+		return append([]string{}, sent...)
+	}
+}
+
+func testAdminMessage(bot *tele.Bot, text string) tele.Context {
+	return bot.NewContext(tele.Update{Message: &tele.Message{
+		ID:     2,
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1},
+		Text:   text,
+	}})
+}
+
+func TestJoi_continuePollWizard(t *testing.T) {
+	bot, sent := newTestBot(t)
+	joi := &Joi{Bot: bot, pollWizards: &pollWizards{wizards: map[int64]*pollWizard{}}}
+	joi.pollWizards.set(1, &pollWizard{
+		step:    pollWizardQuestion,
+		command: &tele.Message{ID: 1},
+		poll:    NewPollInfo(),
+		expires: time.Now().Add(pollWizardTimeout),
+	})
+
+	for _, step := range []struct {
+		text     string
+		expected int
+	}{
+		{strings.Repeat("?", TelegramMaximumPollQuestion+1), pollWizardQuestion},
+		{" ", pollWizardQuestion},
+		{"which one?", pollWizardOptions},
+		{"the only one", pollWizardOptions},
+		{"first\nsecond", pollWizardSettings},
+	} {
+		err := joi.continuePollWizard(testAdminMessage(bot, step.text), joi.pollWizards.get(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wizard := joi.pollWizards.get(1); wizard.step != step.expected {
+			replies := sent()
+			t.Errorf("after '%s' the wizard is at step %d, expected %d, replied '%s'",
+				excerpt(step.text, 20), wizard.step, step.expected, replies[len(replies)-1])
+		}
+	}
+	wizard := joi.pollWizards.get(1)
+	if wizard.poll.Question != "which one?" {
+		t.Errorf("question is '%s'", wizard.poll.Question)
+	}
+	if wizard.prompt == nil {
+		t.Errorf("the last question isn't remembered")
+	}
+
+	// the copy isn't seen by others until it's updated
+	wizard.step = pollWizardQuestion
+	wizard.poll.Question = "changed"
+	if stored := joi.pollWizards.get(1); stored.step != pollWizardSettings || stored.poll.Question != "which one?" {
+		t.Errorf("the stored wizard is changed without update, step %d, question '%s'", stored.step, stored.poll.Question)
+	}
+
+	// the cancelled wizard isn't brought back
+	joi.pollWizards.set(1, nil)
+	err := joi.continuePollWizard(testAdminMessage(bot, "first\nsecond"), wizard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joi.pollWizards.get(1) != nil {
+		t.Errorf("the cancelled wizard is back")
+	}
+}
+
+func TestPollWizards_get(t *testing.T) {
+	wizards := &pollWizards{wizards: map[int64]*pollWizard{}}
+	wizards.set(1, &pollWizard{poll: NewPollInfo(), expires: time.Now().Add(-time.Second)})
+	if wizards.get(1) != nil {
+		t.Errorf("the expired wizard is returned")
+	}
+	if _, ok := wizards.wizards[1]; ok {
+		t.Errorf("the expired wizard is kept")
+	}
+}
+
+func TestPollWizard_waitsFor(t *testing.T) {
+	wizard := &pollWizard{command: &tele.Message{ID: 1}, prompt: &tele.Message{ID: 5}}
+	for _, test := range []struct {
+		replyTo  *tele.Message
+		expected bool
+	}{
+		{nil, true},
+		{&tele.Message{ID: 1}, true},
+		{&tele.Message{ID: 5}, true},
+		{&tele.Message{ID: 3}, false},
+	} {
+		if actual := wizard.waitsFor(&tele.Message{ID: 7, ReplyTo: test.replyTo}); actual != test.expected {
+			t.Errorf("waitsFor(%v) = %v, expected %v", test.replyTo, actual, test.expected)
+		}
+	}
+}
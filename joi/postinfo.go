@@ -1,5 +1,7 @@
 package joi
 
+import tele "gopkg.in/telebot.v3"
+
 const (
 	PostSourcesAuto = iota
 	PostSourcesTrue
@@ -11,6 +13,22 @@ const (
 	TelegramFileTypeVideo
 	TelegramFileTypeDocPhoto
 	TelegramFileTypeDocVideo
+	TelegramFileTypeAnimation
+	TelegramFileTypeDocAnimation
+	TelegramFileTypeDocument
+	TelegramFileTypeAudio
+	TelegramFileTypeVoice
+	TelegramFileTypeVideoNote
+	TelegramFileTypeSticker
+)
+
+// Telegram groups into albums only photos with videos, documents with documents and audio with audio,
+// voice messages, video notes and stickers are always sent on their own
+const (
+	AlbumKindVisual = iota
+	AlbumKindDocument
+	AlbumKindAudio
+	AlbumKindStandalone
 )
 
 const (
@@ -20,24 +38,147 @@ const (
 	ChangePostPostSources
 	ChangePostIsProtected
 	ChangePostMsgIdInCommentsChat
+	ChangePostConvertedFile
+	ChangePostDisableWebPagePreview
+	ChangePostButtons
+	ChangePostHeld
+	ChangePostTags
 )
 
 const TimeIsNotSpecified = "NA"
 
 type TgFileInfo struct {
-	Type int
-	Id   string
+	Type        int
+	Id          string
+	ConvertedId string // file id of the already converted and uploaded version, if any
+}
+
+// HasSource tells whether the file was sent as a document, so the original could be posted as a source
+func (file TgFileInfo) HasSource() bool {
+	return file.Type == TelegramFileTypeDocPhoto || file.Type == TelegramFileTypeDocVideo || file.Type == TelegramFileTypeDocAnimation
+}
+
+func (file TgFileInfo) AlbumKind() int {
+	switch file.Type {
+	case TelegramFileTypeDocument:
+		return AlbumKindDocument
+	case TelegramFileTypeAudio:
+		return AlbumKindAudio
+	case TelegramFileTypeVoice, TelegramFileTypeVideoNote, TelegramFileTypeSticker:
+		return AlbumKindStandalone
+	default:
+		return AlbumKindVisual // animations in albums are sent as videos
+	}
+}
+
+// CanHaveCaption tells whether Telegram allows a caption for the file, video notes and stickers can't have one
+func (file TgFileInfo) CanHaveCaption() bool {
+	return file.Type != TelegramFileTypeVideoNote && file.Type != TelegramFileTypeSticker
+}
+
+func (file TgFileInfo) TypeName() string {
+	switch file.Type {
+	case TelegramFileTypePhoto, TelegramFileTypeDocPhoto:
+		return "photo"
+	case TelegramFileTypeVideo, TelegramFileTypeDocVideo:
+		return "video"
+	case TelegramFileTypeAnimation, TelegramFileTypeDocAnimation:
+		return "animation"
+	case TelegramFileTypeDocument:
+		return "document"
+	case TelegramFileTypeAudio:
+		return "audio"
+	case TelegramFileTypeVoice:
+		return "voice message"
+	case TelegramFileTypeVideoNote:
+		return "video note"
+	case TelegramFileTypeSticker:
+		return "sticker"
+	default:
+		return "unknown"
+	}
 }
 
 type PostInfo struct {
 	Id                  string
 	Time                string
-	Text                string
+	Text                string // plain text, formatted by the entities
+	TextEntities        tele.Entities
 	Comment             string
+	CommentEntities     tele.Entities
 	PostSources         int
 	IsProtected         bool
-	Files               []TgFileInfo
+	Files               []TgFileInfo // empty for text only posts
 	MsgIdInCommentsChat int
 	AdminPostedId       int64
 	OriginalMsgIds      []int64
+
+	DisableWebPagePreview bool
+	Poll                  *PollInfo // nil for posts without a poll
+	Buttons               []PostButton
+	Origin                *PostOrigin // nil unless forwarded from another channel
+	Held                  bool        // possible duplicate, isn't posted by the schedule until the admin overrides it
+	Tags                  []string    // lowercase, without #, slots of the schedule may prefer posts with some of them
+}
+
+// Albums splits files of the post into consecutive groups of indexes, each of them Telegram allows to send as an album
+func (post *PostInfo) Albums() [][]int {
+	albums := make([][]int, 0)
+	for i, file := range post.Files {
+		last := len(albums) - 1
+		if last < 0 || len(albums[last]) == TelegramMaximumAlbumSize || !canBeGrouped(post.Files[albums[last][0]], file) {
+			albums = append(albums, []int{i})
+		} else {
+			albums[last] = append(albums[last], i)
+		}
+	}
+	return albums
+}
+
+// UngroupableFiles returns indexes of the files, which can't be sent in the same album with the previous ones
+func (post *PostInfo) UngroupableFiles() []int {
+	ungroupable := make([]int, 0)
+	for i := 1; i < len(post.Files); i++ {
+		if !canBeGrouped(post.Files[i-1], post.Files[i]) {
+			ungroupable = append(ungroupable, i)
+		}
+	}
+	return ungroupable
+}
+
+// CaptionIndex returns index of the file, which carries the text of the post as a caption,
+// it is the last one that can have a caption, -1 if none of them can
+func (post *PostInfo) CaptionIndex() int {
+	for i := len(post.Files) - 1; i >= 0; i-- {
+		if post.Files[i].CanHaveCaption() {
+			return i
+		}
+	}
+	return -1
+}
+
+// CaptionAlbum returns index of the album (see Albums), which carries the text of the post, -1 if none of them
+func (post *PostInfo) CaptionAlbum() int {
+	captionIndex := post.CaptionIndex()
+	for i, album := range post.Albums() {
+		for _, index := range album {
+			if index == captionIndex {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ThreadAlbum returns index of the album, under which comments are posted, the one with the text or the first one.
+// A separate text and a poll follow the albums, so they host comments only if the post has no files.
+func (post *PostInfo) ThreadAlbum() int {
+	if captionAlbum := post.CaptionAlbum(); captionAlbum >= 0 {
+		return captionAlbum
+	}
+	return 0
+}
+
+func canBeGrouped(a TgFileInfo, b TgFileInfo) bool {
+	return a.AlbumKind() == b.AlbumKind() && a.AlbumKind() != AlbumKindStandalone
 }
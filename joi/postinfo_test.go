@@ -0,0 +1,64 @@
+package joi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPostInfo_Albums(t *testing.T) {
+	files := func(types ...int) []TgFileInfo {
+		files := make([]TgFileInfo, len(types))
+		for i, fileType := range types {
+			files[i] = TgFileInfo{Type: fileType, Id: fmt.Sprintf("file %d", i)}
+		}
+		return files
+	}
+	repeat := func(fileType int, n int) []int {
+		types := make([]int, n)
+		for i := range types {
+			types[i] = fileType
+		}
+		return types
+	}
+
+	for _, test := range []struct {
+		types       []int
+		albums      string
+		ungroupable string
+	}{
+		{[]int{TelegramFileTypePhoto}, "[[0]]", "[]"},
+		{[]int{TelegramFileTypePhoto, TelegramFileTypeDocVideo, TelegramFileTypeAnimation}, "[[0 1 2]]", "[]"},
+		{[]int{TelegramFileTypePhoto, TelegramFileTypeDocument, TelegramFileTypeDocument, TelegramFileTypeVideo},
+			"[[0] [1 2] [3]]", "[1 3]"},
+		{repeat(TelegramFileTypePhoto, 12), "[[0 1 2 3 4 5 6 7 8 9] [10 11]]", "[]"},
+		{[]int{TelegramFileTypeAudio, TelegramFileTypeAudio, TelegramFileTypeVoice, TelegramFileTypeVoice},
+			"[[0 1] [2] [3]]", "[2 3]"},
+		{[]int{TelegramFileTypePhoto, TelegramFileTypeSticker, TelegramFileTypeVideoNote},
+			"[[0] [1] [2]]", "[1 2]"},
+	} {
+		post := &PostInfo{Files: files(test.types...)}
+		if albums := fmt.Sprint(post.Albums()); albums != test.albums {
+			t.Errorf("albums of %v are %s, expected %s", test.types, albums, test.albums)
+		}
+		if ungroupable := fmt.Sprint(post.UngroupableFiles()); ungroupable != test.ungroupable {
+			t.Errorf("ungroupable files of %v are %s, expected %s", test.types, ungroupable, test.ungroupable)
+		}
+	}
+}
+
+func TestPostInfo_CaptionIndex(t *testing.T) {
+	for _, test := range []struct {
+		files    []TgFileInfo
+		expected int
+	}{
+		{[]TgFileInfo{}, -1},
+		{[]TgFileInfo{{Type: TelegramFileTypePhoto}, {Type: TelegramFileTypeVideo}}, 1},
+		{[]TgFileInfo{{Type: TelegramFileTypeAudio}, {Type: TelegramFileTypeSticker}}, 0},
+		{[]TgFileInfo{{Type: TelegramFileTypeVideoNote}, {Type: TelegramFileTypeSticker}}, -1},
+	} {
+		post := &PostInfo{Files: test.files}
+		if index := post.CaptionIndex(); index != test.expected {
+			t.Errorf("caption index of %v is %d, expected %d", test.files, index, test.expected)
+		}
+	}
+}
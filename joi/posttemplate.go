@@ -0,0 +1,161 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+)
+
+// the private use runes mark boundaries of entities, while the text is executed as a template
+const templateMarkerBase = 0xF0000
+
+var templateActionRegexp = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+func hasTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// usesPostNumber tells whether the post shows its number, only such posts are counted
+func usesPostNumber(post *PostInfo) bool {
+	return hasTemplate(post.Text) && strings.Contains(post.Text, ".PostNumber") ||
+		hasTemplate(post.Comment) && strings.Contains(post.Comment, ".PostNumber")
+}
+
+// postTemplateData returns values of the variables for templates of the post published at the given time.
+// Custom variables of the config don't override the built-in ones.
+func (joi *Joi) postTemplateData(post *PostInfo, at time.Time, postNumber int64, channelLink string) map[string]interface{} {
+	data := map[string]interface{}{}
+	for name, value := range joi.Cfg.TemplateVariables {
+		data[name] = value
+	}
+	data["Now"] = at
+	data["Date"] = at.Format("2006-01-02")
+	data["Time"] = at.Format("15:04")
+	data["Weekday"] = at.Weekday().String()
+	data["PostNumber"] = postNumber
+	data["ChannelLink"] = channelLink
+	data["Tags"] = formatTags(post.Tags)
+	return data
+}
+
+// checkTemplates executes the texts on sample values, so broken templates are rejected before they are saved
+func (joi *Joi) checkTemplates(texts ...string) error {
+	data := joi.postTemplateData(&PostInfo{}, time.Now(), 1, "https://t.me/channel")
+	for _, text := range texts {
+		_, _, err := executeTemplate(text, nil, data)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s\nfor template '%s'", err.Error(), text))
+		}
+	}
+	return nil
+}
+
+// renderPostTemplates returns a copy of the post with the text and the comment executed as templates
+func renderPostTemplates(post *PostInfo, data map[string]interface{}) (*PostInfo, error) {
+	rendered := *post
+	var err error
+	rendered.Text, rendered.TextEntities, err = executeTemplate(post.Text, post.TextEntities, data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s\nfor text of `%s`", err.Error(), post.Id))
+	}
+	rendered.Comment, rendered.CommentEntities, err = executeTemplate(post.Comment, post.CommentEntities, data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s\nfor comment of `%s`", err.Error(), post.Id))
+	}
+	return &rendered, nil
+}
+
+// executeTemplate executes the plain text as a template, entities stay around the same pieces of the text.
+// Boundaries of entities are marked inside the text, the ones inside actions are moved out of them,
+// entities which boundaries are dropped by the template are dropped as well.
+func executeTemplate(text string, entities tele.Entities, data interface{}) (string, tele.Entities, error) {
+	if !hasTemplate(text) {
+		return text, entities, nil
+	}
+
+	type action struct{ start, end int }
+	actions := make([]action, 0)
+	for _, match := range templateActionRegexp.FindAllStringIndex(text, -1) {
+		actions = append(actions, action{utf16Length(text[:match[0]]), utf16Length(text[:match[1]])})
+	}
+
+	type bounds struct{ start, end int }
+	entityBounds := make([]bounds, len(entities))
+	boundaries := map[int]int{} // utf16 offset -> index of the marker
+	for i, entity := range entities {
+		start, end := entity.Offset, entity.Offset+entity.Length
+		for _, a := range actions {
+			if a.start < start && start < a.end {
+				start = a.start
+			}
+			if a.start < end && end < a.end {
+				end = a.end
+			}
+		}
+		entityBounds[i] = bounds{start, end}
+		for _, offset := range []int{start, end} {
+			if _, ok := boundaries[offset]; !ok {
+				boundaries[offset] = len(boundaries)
+			}
+		}
+	}
+
+	marked := strings.Builder{}
+	offset := 0
+	mark := func() {
+		if marker, ok := boundaries[offset]; ok {
+			marked.WriteRune(rune(templateMarkerBase + marker))
+		}
+	}
+	for _, r := range text {
+		mark()
+		marked.WriteRune(r)
+		offset++
+		if utf16.RuneLen(r) == 2 {
+			mark() // an entity shouldn't split the surrogate pair, but it's not lost if it does
+			offset++
+		}
+	}
+	mark()
+
+	tmpl, err := template.New("post").Option("missingkey=error").Parse(marked.String())
+	if err != nil {
+		return "", nil, err
+	}
+	executed := strings.Builder{}
+	err = tmpl.Execute(&executed, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	result := strings.Builder{}
+	positions := make(map[int]int, len(boundaries)) // index of the marker -> utf16 offset in the result
+	length := 0
+	for _, r := range executed.String() {
+		if marker := int(r) - templateMarkerBase; marker >= 0 && marker < len(boundaries) {
+			if _, ok := positions[marker]; !ok {
+				positions[marker] = length
+			}
+			continue
+		}
+		result.WriteRune(r)
+		length += utf16.RuneLen(r)
+	}
+
+	var executedEntities tele.Entities
+	for i, entity := range entities {
+		start, startOk := positions[boundaries[entityBounds[i].start]]
+		end, endOk := positions[boundaries[entityBounds[i].end]]
+		if !startOk || !endOk || end <= start {
+			continue
+		}
+		entity.Offset, entity.Length = start, end-start
+		executedEntities = append(executedEntities, entity)
+	}
+	return result.String(), executedEntities, nil
+}
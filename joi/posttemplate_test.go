@@ -0,0 +1,46 @@
+package joi
+
+import (
+	tele "gopkg.in/telebot.v3"
+	"testing"
+	"time"
+)
+
+func TestExecuteTemplate(t *testing.T) {
+	joi := &Joi{Cfg: Config{TemplateVariables: map[string]string{"Author": "Joi", "Date": "overridden"}}}
+	at := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	data := joi.postTemplateData(&PostInfo{Tags: []string{"art", "анимация"}}, at, 42, "https://t.me/channel")
+
+	for _, test := range []struct {
+		text     string
+		entities tele.Entities
+		expected string
+		result   string
+	}{
+		{"no templates {here}", tele.Entities{{Type: tele.EntityBold, Offset: 3, Length: 9}}, "no templates {here}", "[bold 3 9]"},
+		{"#{{.PostNumber}} {{.Weekday}}, {{.Date}}", nil, "#42 Sunday, 2026-10-18", "[]"},
+		{"by {{.Author}} {{.Tags}}", tele.Entities{{Type: tele.EntityItalic, Offset: 3, Length: 11}}, "by Joi #art #анимация", "[italic 3 3]"},
+		{"🔥 {{.ChannelLink}} 🔥", tele.Entities{{Type: tele.EntityBold, Offset: 3, Length: 4}, {Type: tele.EntityCustomEmoji, Offset: 20, Length: 2}},
+			"🔥 https://t.me/channel 🔥", "[bold 3 20 custom_emoji 24 2]"},
+		{"{{if .Missing}}gone{{end}}x", nil, "", "error"},
+		{"{{if false}}gone{{end}}kept", tele.Entities{{Type: tele.EntityBold, Offset: 12, Length: 4}, {Type: tele.EntityItalic, Offset: 23, Length: 4}}, "kept", "[italic 0 4]"},
+		{"{{.Date", nil, "", "error"},
+	} {
+		text, entities, err := executeTemplate(test.text, test.entities, data)
+		if test.result == "error" {
+			if err == nil {
+				t.Errorf("'%s' is expected to fail", test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s' failed: %s", test.text, err.Error())
+		} else if text != test.expected || formatTestEntities(entities) != test.result {
+			t.Errorf("'%s' is executed into '%s' %s, expected '%s' %s", test.text, text, formatTestEntities(entities), test.expected, test.result)
+		}
+	}
+
+	if joi.checkTemplates("{{.Author}} {{.PostNumber}}") != nil || joi.checkTemplates("{{.Unknown}}") == nil {
+		t.Errorf("templates are checked incorrectly")
+	}
+}
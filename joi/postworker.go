@@ -1,17 +1,18 @@
 package joi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	tele "gopkg.in/telebot.v3"
 	"log"
 	"os"
-	"path"
 	"sync"
 	"time"
 )
 
 const RetriesNumber = 3
+const TelegramMaximumAlbumSize = 10
 
 type PostWorker struct {
 	Joi               *Joi
@@ -19,7 +20,7 @@ type PostWorker struct {
 	TimeoutForSources time.Duration
 	OnError           func(error)
 
-	lastPosts    map[int]string // post id in the channel -> post_id in database
+	lastPosts    map[int]postedMessage // post id in the channel -> post_id in database
 	postingMutex sync.Mutex
 }
 
@@ -34,18 +35,20 @@ func NewPostWorker(joi *Joi, period ...time.Duration) *PostWorker {
 		PollingTimeout:    period_,
 		TimeoutForSources: time.Minute,
 		OnError:           func(error) {},
-		lastPosts:         map[int]string{},
+		lastPosts:         map[int]postedMessage{},
 		postingMutex:      sync.Mutex{},
 	}
 }
 
-func (worker *PostWorker) isDefaultPostTime(t string) bool {
-	for _, postTime := range worker.Joi.Cfg.DefaultPostTimes {
+// slotTags returns the tags, posts of which the default slot at the time prefers, and whether there's such slot
+func (worker *PostWorker) slotTags(t string) ([]string, bool) {
+	for _, entry := range worker.Joi.Cfg.DefaultPostTimes {
+		postTime, tags := parseScheduleEntry(entry)
 		if t == postTime {
-			return true
+			return tags, true
 		}
 	}
-	return false
+	return nil, false
 }
 
 func (worker *PostWorker) Start() {
@@ -60,14 +63,39 @@ func (worker *PostWorker) Start() {
 		if err != nil {
 			worker.OnError(err)
 		}
+		err = worker.StopDuePolls(tick)
+		if err != nil {
+			worker.OnError(err)
+		}
 	}
 }
 
+// StopDuePolls stops the posted polls, which close time has come
+func (worker *PostWorker) StopDuePolls(now time.Time) error {
+	polls, err := worker.Joi.Database.PopDuePollStops(now)
+	if err != nil {
+		return err
+	}
+	for _, poll := range polls {
+		_, err = worker.Joi.Bot.StopPoll(poll)
+		if err != nil {
+			worker.OnError(errors.New(fmt.Sprintf("while stopping poll %s in chat %d, an error occured: %s", poll.MessageID, poll.ChatID, err.Error())))
+		}
+	}
+	return nil
+}
+
+// PostForTime posts the post scheduled for the time, if there's none and the time is a default slot,
+// a free post is taken, the one with tags of the slot if any
 func (worker *PostWorker) PostForTime(time string) ([]tele.Message, error) {
 	post, err := worker.Joi.Database.GetRandomPostByTime(time)
-	if IsErrRedisNotFound(err) && worker.isDefaultPostTime(time) {
+	tags, isDefault := worker.slotTags(time)
+	if IsErrRedisNotFound(err) && isDefault {
 		for i := 0; i < RetriesNumber; i++ {
-			post, err = worker.Joi.Database.GetRandomPostByTime(TimeIsNotSpecified)
+			post, err = worker.Joi.Database.GetRandomPostByTags(TimeIsNotSpecified, tags)
+			if IsErrRedisNotFound(err) {
+				post, err = worker.Joi.Database.GetRandomPostByTime(TimeIsNotSpecified)
+			}
 			if err != nil {
 				return nil, err
 			} else {
@@ -99,14 +127,28 @@ func (worker *PostWorker) genSendOptions(isProtected bool) *tele.SendOptions {
 		ReplyMarkup:           nil,
 		DisableWebPagePreview: worker.Joi.Cfg.DisableWebPagePreview,
 		DisableNotification:   worker.Joi.Cfg.DisableNotification,
-		ParseMode:             worker.Joi.Cfg.ParseMode,
+		ParseMode:             worker.Joi.parseMode(),
 		AllowWithoutReply:     true,
 		Protected:             isProtected,
 	}
 }
 
+// PostExtended sends the post to the chat, templates of the text and the comment are executed right before that
 func (worker *PostWorker) PostExtended(post *PostInfo, chatId int64, opts *tele.SendOptions, deleteFromDatabase bool) ([]tele.Message, error) {
-	album, sources, downloaded, err := worker.Joi.postInfoToTelegramAlbum(post)
+	numbered := chatId == worker.Joi.Cfg.ChannelId && usesPostNumber(post)
+	data, err := worker.templateData(post)
+	if err != nil {
+		return nil, err
+	}
+	post, err = renderPostTemplates(post, data)
+	if err != nil {
+		return nil, err
+	}
+	if worker.Joi.Cfg.OverflowTextToComment {
+		post = overflowText(post)
+	}
+
+	albums, sources, downloaded, err := worker.Joi.postInfoToTelegramAlbums(post)
 	defer func() {
 		for _, file := range downloaded {
 			err := os.Remove(file)
@@ -122,11 +164,76 @@ func (worker *PostWorker) PostExtended(post *PostInfo, chatId int64, opts *tele.
 	if opts == nil {
 		opts = worker.genSendOptions(post.IsProtected)
 	}
-	messages, err := worker.Joi.Bot.SendAlbum(&tele.Chat{ID: chatId}, album, opts)
-	if err != nil {
+	// buttons go to the last message of the post, unless it is an album, which can't carry them
+	withButtons := *opts
+	withButtons.ReplyMarkup = post.ReplyMarkup()
+	separateText := post.Text != "" && post.CaptionIndex() < 0
+	lastIsAlbum := post.Poll == nil && !separateText && len(albums) > 0 && len(albums[len(albums)-1]) > 1
+
+	var sent [][]tele.Message
+	captionAlbum := post.CaptionAlbum()
+	for i := range albums {
+		albumOpts := opts
+		if i == captionAlbum {
+			albumOpts = worker.Joi.formatOptions(opts, post.TextEntities)
+		}
+		if i == len(albums)-1 && post.Poll == nil && !separateText && !lastIsAlbum {
+			withMarkup := *albumOpts
+			withMarkup.ReplyMarkup = withButtons.ReplyMarkup
+			albumOpts = &withMarkup
+		}
+		var albumSent [][]tele.Message
+		albumSent, err = worker.sendMedia(&tele.Chat{ID: chatId}, albums[i:i+1], albumOpts)
+		sent = append(sent, albumSent...)
+		if err != nil {
+			break
+		}
+	}
+	// text only posts and posts of files without captions (i.e. stickers) get the text as a separate message
+	if err == nil && separateText {
+		textOpts := opts
+		if post.Poll == nil {
+			textOpts = &withButtons
+		}
+		var text [][]tele.Message
+		text, err = worker.sendText(&tele.Chat{ID: chatId}, post, textOpts)
+		sent = append(sent, text...)
+	}
+	if err == nil && post.Poll != nil {
+		var poll [][]tele.Message
+		poll, err = worker.sendPoll(&tele.Chat{ID: chatId}, post, &withButtons, chatId == worker.Joi.Cfg.ChannelId)
+		sent = append(sent, poll...)
+	}
+	worker.AddPosted(post, sent...)
+	messages := make([]tele.Message, 0, len(post.Files))
+	for _, albumMessages := range sent {
+		messages = append(messages, albumMessages...)
+	}
+	if err == nil && lastIsAlbum && withButtons.ReplyMarkup != nil {
+		// not a part of the post for AddPosted, so the comments thread stays under the album
+		var message *tele.Message
+		message, err = worker.Joi.Bot.Send(&tele.Chat{ID: chatId}, worker.Joi.Cfg.ButtonsMessageText, &withButtons)
+		if err == nil {
+			messages = append(messages, *message)
+		}
+	}
+	if err != nil && (chatId != worker.Joi.Cfg.ChannelId || len(sent) == 0) {
 		return nil, err
 	}
-	worker.AddPosted(post, messages...)
+	if chatId == worker.Joi.Cfg.ChannelId && len(sent) > 0 {
+		worker.publishMediaHashes(post, &sent[0][0])
+	}
+	if numbered {
+		_, numberErr := worker.Joi.Database.NextPostNumber()
+		if numberErr != nil {
+			worker.OnError(errors.New(fmt.Sprintf("while counting `%s`\nan error occured:%s", post.Id, numberErr.Error())))
+		}
+	}
+	if err != nil {
+		// the sent messages are already in the channel, so posting it again would duplicate them
+		worker.removePartiallyPosted(post, err, deleteFromDatabase)
+		return messages, nil
+	}
 
 	if post.PostSources == PostSourcesAuto {
 		post.PostSources = PostSourcesFalse
@@ -134,12 +241,11 @@ func (worker *PostWorker) PostExtended(post *PostInfo, chatId int64, opts *tele.
 	switch post.PostSources {
 	case PostSourcesTrue:
 		if chatId == worker.Joi.Cfg.ChannelId {
-			go worker.sourcePostingPolling(post.Id, sources, deleteFromDatabase)()
+			go worker.sourcePostingPolling(post.Id, splitMedia(sources, TelegramMaximumAlbumSize), post.CommentEntities, deleteFromDatabase)()
 		} else {
-			_, err := worker.Joi.Bot.SendAlbum(&tele.Chat{ID: chatId}, sources,
+			_, err := worker.sendSources(&tele.Chat{ID: chatId}, splitMedia(sources, TelegramMaximumAlbumSize), post.CommentEntities,
 				&tele.SendOptions{
 					Protected: post.IsProtected,
-					ParseMode: worker.Joi.Cfg.ParseMode,
 				})
 			if err != nil {
 				return nil, err
@@ -148,13 +254,10 @@ func (worker *PostWorker) PostExtended(post *PostInfo, chatId int64, opts *tele.
 	case PostSourcesFalse:
 		if post.Comment != "" {
 			if chatId == worker.Joi.Cfg.ChannelId {
-				go worker.sourcePostingPolling(post.Id, post.Comment, deleteFromDatabase)()
+				go worker.sourcePostingPolling(post.Id, FormattedText{Text: post.Comment, Entities: post.CommentEntities}, nil, deleteFromDatabase)()
 			} else {
-				_, err := worker.Joi.Bot.Send(&tele.Chat{ID: chatId}, post.Comment,
-					&tele.SendOptions{
-						Protected: post.IsProtected,
-						ParseMode: worker.Joi.Cfg.ParseMode,
-					})
+				_, err := worker.Joi.Bot.Send(&tele.Chat{ID: chatId}, worker.Joi.render(post.Comment, post.CommentEntities),
+					worker.Joi.formatOptions(&tele.SendOptions{Protected: post.IsProtected}, post.CommentEntities))
 				if err != nil {
 					return nil, err
 				}
@@ -174,7 +277,158 @@ func (worker *PostWorker) PostExtended(post *PostInfo, chatId int64, opts *tele.
 	return messages, nil
 }
 
-func (worker *PostWorker) sourcePostingPolling(postId string, comment interface{}, deleteFromDatabase bool) func() {
+// removePartiallyPosted removes the post, which is published only in part, and tells the admin what's failed
+func (worker *PostWorker) removePartiallyPosted(post *PostInfo, err error, deleteFromDatabase bool) {
+	worker.OnError(errors.New(fmt.Sprintf("`%s` is published only in part, since an error occured:%s", post.Id, err.Error())))
+	if len(post.OriginalMsgIds) > 0 {
+		_, replyErr := worker.Joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[0]), Chat: &tele.Chat{ID: post.AdminPostedId}},
+			fmt.Sprintf("only part of this post is published, so it's removed from the queue, %s", err.Error()))
+		if replyErr != nil {
+			worker.OnError(replyErr)
+		}
+	}
+	if deleteFromDatabase {
+		err = worker.Joi.Database.RemovePost(post.Id)
+		if err != nil {
+			worker.OnError(errors.New(fmt.Sprintf("while removing partially published `%s`\nan error occured:%s", post.Id, err.Error())))
+		}
+	}
+}
+
+// templateData returns values for templates of the post,
+// the number is only peeked, since it's counted after the post is published
+func (worker *PostWorker) templateData(post *PostInfo) (map[string]interface{}, error) {
+	if !hasTemplate(post.Text) && !hasTemplate(post.Comment) {
+		return nil, nil
+	}
+	number, err := worker.Joi.Database.PeekPostNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := worker.Joi.Bot.ChatByID(worker.Joi.Cfg.ChannelId)
+	if err != nil {
+		channel = &tele.Chat{ID: worker.Joi.Cfg.ChannelId}
+	}
+	return worker.Joi.postTemplateData(post, time.Now(), number, chatLink(channel)), nil
+}
+
+func (worker *PostWorker) sendText(chat *tele.Chat, post *PostInfo, opts *tele.SendOptions) ([][]tele.Message, error) {
+	textOpts := *opts
+	textOpts.DisableWebPagePreview = post.DisableWebPagePreview
+	message, err := worker.Joi.Bot.Send(chat, worker.Joi.render(post.Text, post.TextEntities), worker.Joi.formatOptions(&textOpts, post.TextEntities))
+	if err != nil {
+		return nil, err
+	}
+	return [][]tele.Message{{*message}}, nil
+}
+
+// sendSources sends the sources, the last one carries the comment as a caption
+func (worker *PostWorker) sendSources(chat *tele.Chat, sources [][]tele.Sendable, commentEntities tele.Entities, opts *tele.SendOptions) ([][]tele.Message, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	last := len(sources) - 1
+	sent, err := worker.sendMedia(chat, sources[:last], worker.Joi.formatOptions(opts, nil))
+	if err != nil {
+		return sent, err
+	}
+	lastSent, err := worker.sendMedia(chat, sources[last:], worker.Joi.formatOptions(opts, commentEntities))
+	return append(sent, lastSent...), err
+}
+
+// publishMediaHashes moves hashes of the posted photos to the history, so the future duplicates are linked to the message
+func (worker *PostWorker) publishMediaHashes(post *PostInfo, posted *tele.Message) {
+	chat, err := worker.Joi.Bot.ChatByID(posted.Chat.ID)
+	if err != nil {
+		chat = posted.Chat
+	}
+	err = worker.Joi.Database.PublishMediaHashes(post.Id, messageLink(chat, posted.ID))
+	if err != nil {
+		worker.OnError(errors.New(fmt.Sprintf("while publishing hashes of `%s`\nan error occured:%s", post.Id, err.Error())))
+	}
+}
+
+// sendPoll sends the poll of the post, if scheduleStop is set and the poll has a close time, Joi stops it later
+func (worker *PostWorker) sendPoll(chat *tele.Chat, post *PostInfo, opts *tele.SendOptions, scheduleStop bool) ([][]tele.Message, error) {
+	message, err := worker.Joi.Bot.Send(chat, post.Poll.ToTelegram(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleStop && post.Poll.CloseAfter > 0 {
+		err = worker.Joi.Database.AddPollStop(chat.ID, message.ID, time.Now().Add(post.Poll.CloseAfter))
+		if err != nil {
+			worker.OnError(errors.New(fmt.Sprintf("poll of `%s` won't be stopped, %s", post.Id, err.Error())))
+		}
+	}
+	return [][]tele.Message{{*message}}, nil
+}
+
+// sendMedia sends the albums one after another, a single media is sent as a regular message,
+// since some of them (i.e. animations, stickers) can't be sent in albums.
+// Returns messages of every sent album, even if some of them has failed to be sent.
+func (worker *PostWorker) sendMedia(chat *tele.Chat, albums [][]tele.Sendable, opts *tele.SendOptions) ([][]tele.Message, error) {
+	sent := make([][]tele.Message, 0, len(albums))
+	for _, media := range albums {
+		if len(media) == 1 {
+			message, err := worker.Joi.Bot.Send(chat, media[0], opts)
+			if err != nil {
+				return sent, err
+			}
+			sent = append(sent, []tele.Message{*message})
+			continue
+		}
+
+		album := make(tele.Album, len(media))
+		for i := range media {
+			inputtable, ok := media[i].(tele.Inputtable)
+			if !ok {
+				return sent, errors.New(fmt.Sprintf("%T can't be sent in an album", media[i]))
+			}
+			if len(opts.Entities) > 0 && inputtable.InputMedia().Caption != "" {
+				inputtable = captionedMedia{Inputtable: inputtable, entities: opts.Entities}
+			}
+			album[i] = inputtable
+		}
+		// SendAlbum would attach the entities to every item of the album, not only to the captioned one
+		albumOpts := *opts
+		albumOpts.Entities = nil
+		messages, err := worker.Joi.Bot.SendAlbum(chat, album, &albumOpts)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, messages)
+	}
+	return sent, nil
+}
+
+// captionedMedia is the album item, which caption is formatted by the entities
+type captionedMedia struct {
+	tele.Inputtable
+	entities tele.Entities
+}
+
+func (media captionedMedia) InputMedia() tele.InputMedia {
+	inputMedia := media.Inputtable.InputMedia()
+	inputMedia.Entities = media.entities
+	return inputMedia
+}
+
+func splitMedia(media []tele.Sendable, size int) [][]tele.Sendable {
+	parts := make([][]tele.Sendable, 0, (len(media)+size-1)/size)
+	for len(media) > size {
+		parts = append(parts, media[:size])
+		media = media[size:]
+	}
+	if len(media) > 0 {
+		parts = append(parts, media)
+	}
+	return parts
+}
+
+// sourcePostingPolling waits for the post to reach the comments chat and replies with the sources
+// (captioned by the comment with commentEntities) or the comment itself
+func (worker *PostWorker) sourcePostingPolling(postId string, comment interface{}, commentEntities tele.Entities, deleteFromDatabase bool) func() {
 	return func() {
 		if r := recover(); r != nil {
 			worker.OnError(errors.New(fmt.Sprintf("%v", r)))
@@ -193,23 +447,21 @@ func (worker *PostWorker) sourcePostingPolling(postId string, comment interface{
 			}
 			if post.MsgIdInCommentsChat != 0 {
 				switch comment.(type) {
-				case tele.Album:
-					_, err = worker.Joi.Bot.SendAlbum(&tele.Chat{ID: worker.Joi.Cfg.CommentsId},
-						comment.(tele.Album),
+				case [][]tele.Sendable:
+					_, err = worker.sendSources(&tele.Chat{ID: worker.Joi.Cfg.CommentsId},
+						comment.([][]tele.Sendable), commentEntities,
 						&tele.SendOptions{
 							ReplyTo:   &tele.Message{ID: post.MsgIdInCommentsChat, Chat: &tele.Chat{ID: worker.Joi.Cfg.CommentsId}},
 							Protected: post.IsProtected,
-							ParseMode: worker.Joi.Cfg.ParseMode,
 						},
 					)
-				case string:
+				case FormattedText:
 					_, err = worker.Joi.Bot.Send(&tele.Chat{ID: worker.Joi.Cfg.CommentsId},
-						comment.(string),
-						&tele.SendOptions{
+						worker.Joi.render(comment.(FormattedText).Text, comment.(FormattedText).Entities),
+						worker.Joi.formatOptions(&tele.SendOptions{
 							ReplyTo:   &tele.Message{ID: post.MsgIdInCommentsChat, Chat: &tele.Chat{ID: worker.Joi.Cfg.CommentsId}},
 							Protected: post.IsProtected,
-							ParseMode: worker.Joi.Cfg.ParseMode,
-						},
+						}, comment.(FormattedText).Entities),
 					)
 				default:
 					err = errors.New("unsupported type of comment is provided")
@@ -230,80 +482,194 @@ func (worker *PostWorker) sourcePostingPolling(postId string, comment interface{
 	}
 }
 
-func (joi *Joi) postInfoToTelegramAlbum(post *PostInfo) (album tele.Album, sources tele.Album, downloaded []string, err error) {
-	album = make(tele.Album, 0)
-	sources = make(tele.Album, 0)
-	downloaded = make([]string, 0)
-	for i, file := range post.Files {
-		caption := ""
-		if i+1 == len(post.Files) {
-			caption = post.Text
-		}
-		comment := ""
-		if i+1 == len(post.Files) {
-			comment = post.Comment
+// postInfoToTelegramAlbums prepares files of the post to be sent as consecutive albums, see PostInfo.Albums
+func (joi *Joi) postInfoToTelegramAlbums(post *PostInfo) (albums [][]tele.Sendable, sources []tele.Sendable, downloaded []string, err error) {
+	media := make([]tele.Sendable, len(post.Files))
+	mediaSources := make([]tele.Sendable, len(post.Files))
+	captionIndex := post.CaptionIndex()
+	downloadedMutex := sync.Mutex{}
+
+	jobs := make([]func(context.Context) error, 0, len(post.Files))
+	for _, album := range post.Albums() {
+		for _, i := range album {
+			i, file, inAlbum := i, post.Files[i], len(album) > 1
+			caption := ""
+			if i == captionIndex {
+				caption = joi.render(post.Text, post.TextEntities)
+			}
+			comment := ""
+			if i+1 == len(post.Files) {
+				comment = joi.render(post.Comment, post.CommentEntities)
+			}
+
+			jobs = append(jobs, func(ctx context.Context) error {
+				var files []string
+				var err error
+				media[i], mediaSources[i], files, err = joi.fileToTelegramMedia(ctx, file, caption, comment, inAlbum)
+
+				downloadedMutex.Lock()
+				downloaded = append(downloaded, files...)
+				downloadedMutex.Unlock()
+
+				return err
+			})
 		}
+	}
 
-		fileOnServer, err := joi.Bot.FileByID(file.Id)
-		if err != nil {
-			return nil, nil, downloaded, err
+	err = firstError(joi.postingPool.Run(jobs...))
+	if err != nil {
+		return nil, nil, downloaded, err
+	}
+
+	albums = make([][]tele.Sendable, 0)
+	for _, indexes := range post.Albums() {
+		album := make([]tele.Sendable, 0, len(indexes))
+		for _, i := range indexes {
+			if media[i] != nil {
+				album = append(album, media[i])
+			}
+		}
+		if len(album) > 0 {
+			albums = append(albums, album)
+		}
+	}
+	sources = make([]tele.Sendable, 0)
+	for i := range post.Files {
+		if mediaSources[i] != nil {
+			sources = append(sources, mediaSources[i])
 		}
+	}
+	return albums, sources, downloaded, nil
+}
 
-		switch file.Type {
-		case TelegramFileTypePhoto:
-			album = append(album, &tele.Photo{
-				File:    fileOnServer,
-				Caption: caption,
-			})
-		case TelegramFileTypeVideo:
-			album = append(album, &tele.Video{
-				File:    fileOnServer,
-				Caption: caption,
-			})
-		case TelegramFileTypeDocPhoto:
-			localFileName := path.Join(joi.Cfg.TemporaryFilesDirectory, file.Id)
-			err = joi.Bot.Download(&fileOnServer, localFileName)
+// fileToTelegramMedia prepares the file to be sent, converting it if needed,
+// animations in albums are turned into silent videos, since Telegram doesn't allow to group animations
+func (joi *Joi) fileToTelegramMedia(ctx context.Context, file TgFileInfo, caption string, comment string, inAlbum bool) (media tele.Sendable, source tele.Sendable, downloaded []string, err error) {
+	fileOnServer, err := joi.Bot.FileByID(file.Id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	switch file.Type {
+	case TelegramFileTypePhoto:
+		media = &tele.Photo{
+			File:    fileOnServer,
+			Caption: caption,
+		}
+	case TelegramFileTypeVideo:
+		media = &tele.Video{
+			File:    fileOnServer,
+			Caption: caption,
+		}
+	case TelegramFileTypeDocPhoto:
+		media = &tele.Photo{
+			File:    tele.File{FileID: file.ConvertedId},
+			Caption: caption,
+		}
+		if file.ConvertedId == "" {
+			var converted string
+			var convertedType int
+			converted, convertedType, downloaded, err = joi.convertForTelegram(ctx, file)
 			if err != nil {
 				return nil, nil, downloaded, err
 			}
-			downloaded = append(downloaded, localFileName)
+			switch {
+			case convertedType == TelegramFileTypeDocAnimation && inAlbum:
+				media = &tele.Video{File: tele.FromDisk(converted), Caption: caption}
+			case convertedType == TelegramFileTypeDocAnimation:
+				media = &tele.Animation{File: tele.FromDisk(converted), Caption: caption}
+			default:
+				media = &tele.Photo{File: tele.FromDisk(converted), Caption: caption}
+			}
+		}
 
-			imageForTelegram, err := joi.Converter.ImageTelegram(localFileName)
+		source = &tele.Document{
+			File:    fileOnServer,
+			Caption: comment,
+		}
+	case TelegramFileTypeDocVideo:
+		video := &tele.Video{
+			File:    tele.File{FileID: file.ConvertedId},
+			Caption: caption,
+		}
+		if file.ConvertedId == "" {
+			var converted string
+			converted, _, downloaded, err = joi.convertForTelegram(ctx, file)
 			if err != nil {
 				return nil, nil, downloaded, err
 			}
-			if imageForTelegram != localFileName {
-				downloaded = append(downloaded, localFileName)
-			}
-
-			album = append(album, &tele.Photo{
-				File:    tele.FromDisk(localFileName),
-				Caption: caption,
-			})
+			video.File = tele.FromDisk(converted)
+		}
+		media = video
 
-			sources = append(sources, &tele.Document{
-				File:    fileOnServer,
-				Caption: comment,
-			})
-		case TelegramFileTypeDocVideo:
-			localFileName := path.Join(joi.Cfg.TemporaryFilesDirectory, file.Id)
-			err = joi.Bot.Download(&fileOnServer, localFileName)
+		source = &tele.Document{
+			File:    fileOnServer,
+			Caption: comment,
+		}
+	case TelegramFileTypeDocument:
+		media = &tele.Document{
+			File:    fileOnServer,
+			Caption: caption,
+		}
+	case TelegramFileTypeAudio:
+		media = &tele.Audio{
+			File:    fileOnServer,
+			Caption: caption,
+		}
+	case TelegramFileTypeVoice:
+		media = &tele.Voice{
+			File:    fileOnServer,
+			Caption: caption,
+		}
+	case TelegramFileTypeVideoNote:
+		media = &tele.VideoNote{
+			File: fileOnServer,
+		}
+	case TelegramFileTypeSticker:
+		media = &tele.Sticker{
+			File: fileOnServer,
+		}
+	case TelegramFileTypeAnimation, TelegramFileTypeDocAnimation:
+		switch {
+		case inAlbum:
+			var converted string
+			converted, _, downloaded, err = joi.convertForTelegram(ctx, file)
 			if err != nil {
 				return nil, nil, downloaded, err
 			}
-			downloaded = append(downloaded, localFileName)
-			album = append(album, &tele.Video{
-				File:    tele.FromDisk(localFileName),
+			media = &tele.Video{
+				File:    tele.FromDisk(converted),
 				Caption: caption,
-			})
+			}
+		case file.Type == TelegramFileTypeAnimation:
+			media = &tele.Animation{
+				File:    fileOnServer,
+				Caption: caption,
+			}
+		default:
+			animation := &tele.Animation{
+				File:    tele.File{FileID: file.ConvertedId},
+				Caption: caption,
+			}
+			if file.ConvertedId == "" {
+				var converted string
+				converted, _, downloaded, err = joi.convertForTelegram(ctx, file)
+				if err != nil {
+					return nil, nil, downloaded, err
+				}
+				animation.File = tele.FromDisk(converted)
+			}
+			media = animation
+		}
 
-			sources = append(sources, &tele.Document{
+		if file.Type == TelegramFileTypeDocAnimation {
+			source = &tele.Document{
 				File:    fileOnServer,
 				Caption: comment,
-			})
+			}
 		}
 	}
-	return album, sources, downloaded, nil
+	return media, source, downloaded, nil
 }
 
 func (joi *Joi) postInfoToTelegramDocumentsAlbum(post *PostInfo) (album tele.Album, err error) {
@@ -311,7 +677,7 @@ func (joi *Joi) postInfoToTelegramDocumentsAlbum(post *PostInfo) (album tele.Alb
 	for i, file := range post.Files {
 		caption := ""
 		if i+1 == len(post.Files) {
-			caption = post.Comment
+			caption = joi.render(post.Comment, post.CommentEntities)
 		}
 		fileOnServer, err := joi.Bot.FileByID(file.Id)
 		if err != nil {
@@ -334,27 +700,40 @@ func (joi *Joi) postInfoToTelegramDocumentsAlbum(post *PostInfo) (album tele.Alb
 	return album, nil
 }
 
-func (worker *PostWorker) AddPosted(post *PostInfo, messages ...tele.Message) {
+type postedMessage struct {
+	postId   string
+	isThread bool // the comments are posted under this message
+}
+
+// AddPosted remembers messages of the posted albums, followed by the separate text and the poll, if any,
+// the album given by PostInfo.ThreadAlbum hosts the comments
+func (worker *PostWorker) AddPosted(post *PostInfo, albums ...[]tele.Message) {
 	defer worker.postingMutex.Unlock()
 	worker.postingMutex.Lock()
-	for _, msg := range messages {
-		worker.lastPosts[msg.ID] = post.Id
+	threadAlbum := post.ThreadAlbum()
+	for i, messages := range albums {
+		for _, msg := range messages {
+			worker.lastPosts[msg.ID] = postedMessage{postId: post.Id, isThread: i == threadAlbum}
+		}
 	}
 
 	go func() {
 		defer worker.postingMutex.Unlock()
 		time.Sleep(worker.TimeoutForSources * 2)
 		worker.postingMutex.Lock()
-		for _, msg := range messages {
-			delete(worker.lastPosts, msg.ID)
+		for _, messages := range albums {
+			for _, msg := range messages {
+				delete(worker.lastPosts, msg.ID)
+			}
 		}
 	}()
 }
 
-func (worker *PostWorker) GetPosted(messageId int) (string, bool) {
+// GetPosted returns id of the post, which message in the channel belongs to, and whether comments go under it
+func (worker *PostWorker) GetPosted(messageId int) (id string, isThread bool, ok bool) {
 	defer worker.postingMutex.Unlock()
 	worker.postingMutex.Lock()
 
-	id, ok := worker.lastPosts[messageId]
-	return id, ok
+	posted, ok := worker.lastPosts[messageId]
+	return posted.postId, posted.isThread, ok
 }
@@ -0,0 +1,229 @@
+package joi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostWorker_AddPosted(t *testing.T) {
+	worker := NewPostWorker(&Joi{})
+	photos := make([]TgFileInfo, 12)
+	for i := range photos {
+		photos[i] = TgFileInfo{Type: TelegramFileTypePhoto}
+	}
+	messages := func(ids ...int) []tele.Message {
+		messages := make([]tele.Message, len(ids))
+		for i, id := range ids {
+			messages[i] = tele.Message{ID: id}
+		}
+		return messages
+	}
+
+	for _, test := range []struct {
+		post   *PostInfo
+		sent   [][]tele.Message
+		thread []int
+	}{
+		// two albums with the caption on the second one, followed by the poll
+		{&PostInfo{Id: "poll", Files: photos, Poll: &PollInfo{}},
+			[][]tele.Message{messages(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), messages(11, 12), messages(13)}, []int{11, 12}},
+		// the sticker can't have a caption, so the text is sent after it
+		{&PostInfo{Id: "sticker", Text: "text", Files: []TgFileInfo{{Type: TelegramFileTypeSticker}}},
+			[][]tele.Message{messages(21), messages(22)}, []int{21}},
+		{&PostInfo{Id: "text", Text: "text", Files: []TgFileInfo{}},
+			[][]tele.Message{messages(31)}, []int{31}},
+	} {
+		worker.AddPosted(test.post, test.sent...)
+		thread := make([]int, 0)
+		for _, album := range test.sent {
+			for _, msg := range album {
+				id, isThread, ok := worker.GetPosted(msg.ID)
+				if !ok || id != test.post.Id {
+					t.Errorf("message %d of post %s isn't remembered", msg.ID, test.post.Id)
+				}
+				if isThread {
+					thread = append(thread, msg.ID)
+				}
+			}
+		}
+		if fmt.Sprint(thread) != fmt.Sprint(test.thread) {
+			t.Errorf("comments of post %s go under %v, expected %v", test.post.Id, thread, test.thread)
+		}
+	}
+}
+
+func TestPostWorker_PostNumber(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bot, sent := newTestBot(t)
+	worker := NewPostWorker(&Joi{Bot: bot, Database: db, Cfg: Config{ChannelId: 1}})
+
+	for _, test := range []struct {
+		text     string
+		chatId   int64
+		expected string
+	}{
+		{"#{{.PostNumber}}", 1, "#1"},
+		{"not numbered {{.Weekday}}", 1, ""},
+		{"preview of #{{.PostNumber}}", 2, "preview of #2"},
+		{"#{{.PostNumber}}", 1, "#2"},
+	} {
+		post := &PostInfo{Id: "post", Text: test.text, Files: []TgFileInfo{}, PostSources: PostSourcesFalse}
+		_, err = worker.PostExtended(post, test.chatId, nil, false)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		texts := sent()
+		if test.expected != "" && texts[len(texts)-1] != test.expected {
+			t.Errorf("'%s' is sent as '%s', expected '%s'", test.text, texts[len(texts)-1], test.expected)
+		}
+	}
+	number, err := db.PeekPostNumber()
+	if err != nil || number != 3 {
+		t.Errorf("the next number is %d, expected 3, %v", number, err)
+	}
+}
+
+func TestPostWorker_PartiallyPosted(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// the first album is sent, the second one fails
+	mutex := sync.Mutex{}
+	albums, replies := 0, make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer mutex.Unlock()
+		mutex.Lock()
+		params := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getFile"):
+			_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"file_id":"%s"}}`, params["file_id"])
+		case strings.HasSuffix(r.URL.Path, "/sendMediaGroup"):
+			albums++
+			if albums > 1 {
+				_, _ = fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: the album failed"}`)
+				return
+			}
+			messages := make([]string, 10)
+			for i := range messages {
+				messages[i] = fmt.Sprintf(`{"message_id":%d,"chat":{"id":1},"date":0}`, 100+i)
+			}
+			_, _ = fmt.Fprintf(w, `{"ok":true,"result":[%s]}`, strings.Join(messages, ","))
+		default:
+			if text, ok := params["text"].(string); ok {
+				replies = append(replies, text)
+			}
+			_, _ = fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1},"date":0}}`)
+		}
+	}))
+	defer server.Close()
+	bot, err := tele.NewBot(tele.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joi := &Joi{Bot: bot, Database: db, Cfg: Config{ChannelId: 1}, postingPool: NewConversionPool(1, time.Minute)}
+	defer joi.postingPool.Stop()
+	worker := NewPostWorker(joi)
+
+	post := &PostInfo{Id: "1000_1", Time: "06:06", PostSources: PostSourcesFalse, AdminPostedId: 1000,
+		Files: make([]TgFileInfo, 12), OriginalMsgIds: make([]int64, 12)}
+	for i := range post.Files {
+		post.Files[i] = TgFileInfo{Type: TelegramFileTypePhoto, Id: fmt.Sprintf("photo %d", i)}
+		post.OriginalMsgIds[i] = int64(i + 1)
+	}
+	_, err = db.AddPost(post)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	posted, err := worker.PostForTime("06:06")
+	if err != nil || len(posted) != 10 {
+		t.Fatalf("partially published post is reported as %d messages, %v", len(posted), err)
+	}
+	if albums != 2 {
+		t.Errorf("%d albums are sent, expected 2 without retries", albums)
+	}
+	if contains, _ := db.ContainsPost(post.Id); contains {
+		t.Errorf("partially published post is left in the queue")
+	}
+	if id, _, ok := worker.GetPosted(100); !ok || id != post.Id {
+		t.Errorf("the sent album isn't remembered")
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "the album failed") {
+		t.Errorf("the admin is told %v", replies)
+	}
+}
+
+func TestPostWorker_sendMedia(t *testing.T) {
+	var media []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		_ = json.Unmarshal([]byte(params["media"]), &media)
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":[{"message_id":1,"chat":{"id":1},"date":0},{"message_id":2,"chat":{"id":1},"date":0}]}`)
+	}))
+	defer server.Close()
+	bot, err := tele.NewBot(tele.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joi := &Joi{Bot: bot, Cfg: Config{ParseMode: ModeEntities}}
+	worker := NewPostWorker(joi)
+
+	album := []tele.Sendable{
+		&tele.Photo{File: tele.File{FileID: "first"}},
+		&tele.Photo{File: tele.File{FileID: "second"}, Caption: "caption"},
+	}
+	entities := tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 7}}
+	_, err = worker.sendMedia(&tele.Chat{ID: 1}, [][]tele.Sendable{album}, joi.formatOptions(&tele.SendOptions{}, entities))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 2 || media[0]["caption_entities"] != nil || media[1]["caption_entities"] == nil {
+		t.Errorf("entities of the caption are sent as %v", media)
+	}
+}
+
+func TestJoi_postInfoToTelegramAlbumsBusyPool(t *testing.T) {
+	bot, _ := newTestBot(t)
+	joi := &Joi{Bot: bot, pool: NewConversionPool(1, time.Minute), postingPool: NewConversionPool(1, time.Minute)}
+	defer joi.postingPool.Stop()
+	defer joi.pool.Stop()
+
+	// every slot of the background pool is taken by a long conversion
+	started := make(chan struct{})
+	go joi.pool.Run(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, _, _, err := joi.postInfoToTelegramAlbums(&PostInfo{Files: []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "photo"}}})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the post waits for the background conversions")
+	}
+}
@@ -0,0 +1,191 @@
+package joi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+)
+
+// downloadFile downloads the file from Telegram to the local file, unlike Bot.Download it stops once ctx is done
+func (joi *Joi) downloadFile(ctx context.Context, fileId string, localFileName string) error {
+	fileOnServer, err := joi.Bot.FileByID(fileId)
+	if err != nil {
+		return err
+	}
+
+	url := joi.Bot.URL + "/file/bot" + joi.Bot.Token + "/" + fileOnServer.FilePath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("downloading %s, expected status 200 but got %s", fileId, resp.Status))
+	}
+
+	out, err := os.Create(localFileName)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(localFileName)
+	}
+	return err
+}
+
+// convertForTelegram downloads the file and converts it to something Telegram accepts without compressing,
+// returns the file to upload, the type it has to be sent as and all the temporary files, which have to be removed afterwards.
+// Animated images sent as photos (i.e. animated webp) are converted the same way as gifs, so they are sent as animations.
+func (joi *Joi) convertForTelegram(ctx context.Context, file TgFileInfo) (converted string, convertedType int, downloaded []string, err error) {
+	localFileName := path.Join(joi.Cfg.TemporaryFilesDirectory, file.Id)
+	err = joi.downloadFile(ctx, file.Id, localFileName)
+	if err != nil {
+		return "", file.Type, nil, err
+	}
+	downloaded = append(downloaded, localFileName)
+
+	convertedType = file.Type
+	if file.Type == TelegramFileTypeDocPhoto && joi.Converter.IsAnimatedImage(ctx, localFileName) {
+		convertedType = TelegramFileTypeDocAnimation
+	}
+
+	switch convertedType {
+	case TelegramFileTypeDocPhoto:
+		imageForTelegram, err := joi.Converter.ImageTelegram(ctx, localFileName)
+		if err != nil {
+			return "", convertedType, downloaded, err
+		}
+		if imageForTelegram.Filename != localFileName {
+			downloaded = append(downloaded, imageForTelegram.Filename)
+		}
+		return imageForTelegram.Filename, convertedType, downloaded, nil
+	case TelegramFileTypeDocVideo:
+		return localFileName, convertedType, downloaded, nil
+	case TelegramFileTypeAnimation, TelegramFileTypeDocAnimation:
+		video, err := joi.Converter.AnimationToVideo(ctx, localFileName)
+		if err != nil {
+			return "", convertedType, downloaded, err
+		}
+		downloaded = append(downloaded, video)
+		return video, convertedType, downloaded, nil
+	default:
+		return "", convertedType, downloaded, errors.New(fmt.Sprintf("file of type %d doesn't need to be converted", file.Type))
+	}
+}
+
+// preconvertPost converts document photos, videos and gifs of a freshly added post and uploads them to Telegram,
+// so neither previews nor posting have to download and convert them again
+func (joi *Joi) preconvertPost(post *PostInfo) {
+	jobs := make([]func(context.Context) error, 0)
+	for i, file := range post.Files {
+		if file.ConvertedId != "" || !file.HasSource() {
+			continue
+		}
+		if file.Type == TelegramFileTypeDocAnimation && len(post.Files) > 1 {
+			continue // gifs in albums are sent as videos, which are made at posting time
+		}
+
+		i, file := i, file
+		jobs = append(jobs, func(ctx context.Context) error {
+			convertedId, convertedType, err := joi.uploadConverted(ctx, post, file)
+			if err != nil {
+				_, err = joi.Bot.Reply(&tele.Message{ID: int(post.OriginalMsgIds[i]), Chat: &tele.Chat{ID: post.AdminPostedId}},
+					fmt.Sprintf("couldn't convert this file, %s", err.Error()))
+				if err != nil {
+					log.Printf("while reporting conversion of %s, an error occured %s", file.Id, err.Error())
+				}
+				return nil
+			}
+
+			file.ConvertedId, file.Type = convertedId, convertedType
+			joi.saveConvertedFile(post.AdminPostedId, post.OriginalMsgIds[i], file)
+			return nil
+		})
+	}
+
+	joi.pool.Run(jobs...)
+}
+
+// saveConvertedFile saves the converted file to the post of its original message,
+// since the file could be moved to another post by .merge or .split meanwhile
+func (joi *Joi) saveConvertedFile(adminId int64, msgId int64, file TgFileInfo) {
+	owner, err := joi.Database.GetPostByMessage(adminId, int(msgId))
+	if IsErrRedisNotFound(err) {
+		return // already removed
+	} else if err != nil {
+		log.Printf("while looking for the post of converted %s, an error occured %s", file.Id, err.Error())
+		return
+	}
+	_, err = joi.Database.ChangePost(owner.Id, ChangePostConvertedFile, file)
+	if err != nil && !IsErrRedisNotFound(err) {
+		log.Printf("while saving converted %s of post %s, an error occured %s", file.Id, owner.Id, err.Error())
+	}
+}
+
+// uploadConverted uploads the converted file to the storage chat (or to the admin, deleting it right after)
+// and returns its Telegram file id and the type it is uploaded as
+func (joi *Joi) uploadConverted(ctx context.Context, post *PostInfo, file TgFileInfo) (string, int, error) {
+	converted, convertedType, downloaded, err := joi.convertForTelegram(ctx, file)
+	defer func() {
+		for _, file := range downloaded {
+			err := os.Remove(file)
+			if err != nil {
+				log.Printf("while removing %s, an error occured %s", file, err.Error())
+			}
+		}
+	}()
+	if err != nil {
+		return "", file.Type, err
+	}
+
+	storage := &tele.Chat{ID: joi.Cfg.StorageChatId}
+	if joi.Cfg.StorageChatId == 0 {
+		storage.ID = post.AdminPostedId
+	}
+
+	var media interface{}
+	switch convertedType {
+	case TelegramFileTypeDocPhoto:
+		media = &tele.Photo{File: tele.FromDisk(converted)}
+	case TelegramFileTypeDocVideo:
+		media = &tele.Video{File: tele.FromDisk(converted)}
+	case TelegramFileTypeDocAnimation:
+		media = &tele.Animation{File: tele.FromDisk(converted)}
+	}
+	msg, err := joi.Bot.Send(storage, media, &tele.SendOptions{DisableNotification: true})
+	if err != nil {
+		return "", convertedType, err
+	}
+	if joi.Cfg.StorageChatId == 0 {
+		err = joi.Bot.Delete(msg)
+		if err != nil {
+			log.Printf("while deleting msg %d in chat %d, an error occured %s", msg.ID, msg.Chat.ID, err.Error())
+		}
+	}
+
+	switch {
+	case msg.Photo != nil:
+		return msg.Photo.FileID, convertedType, nil
+	case msg.Video != nil:
+		return msg.Video.FileID, convertedType, nil
+	case msg.Animation != nil:
+		return msg.Animation.FileID, convertedType, nil
+	default:
+		return "", convertedType, errors.New("telegram hasn't returned the uploaded file")
+	}
+}
@@ -0,0 +1,111 @@
+package joi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoi_downloadFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getFile"):
+			params := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"file_id":"%s","file_path":"documents/%s"}}`, params["file_id"], params["file_id"])
+		case strings.HasSuffix(r.URL.Path, "/documents/fast"):
+			_, _ = w.Write([]byte("content"))
+		case strings.HasSuffix(r.URL.Path, "/documents/missing"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			<-r.Context().Done() // the slow file is never sent
+		}
+	}))
+	defer server.Close()
+
+	bot, err := tele.NewBot(tele.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joi := &Joi{Bot: bot}
+	directory := t.TempDir()
+
+	err = joi.downloadFile(context.Background(), "fast", path.Join(directory, "fast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path.Join(directory, "fast"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("downloaded '%s', %v", content, err)
+	}
+
+	err = joi.downloadFile(context.Background(), "missing", path.Join(directory, "missing"))
+	if err == nil {
+		t.Errorf("the missing file is downloaded")
+	}
+	if _, err := os.Stat(path.Join(directory, "missing")); !os.IsNotExist(err) {
+		t.Errorf("the file is created for the failed download")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = joi.downloadFile(ctx, "slow", path.Join(directory, "slow"))
+	if err == nil || ctx.Err() == nil {
+		t.Errorf("the download isn't stopped by the context")
+	}
+	if _, err := os.Stat(path.Join(directory, "slow")); !os.IsNotExist(err) {
+		t.Errorf("the partially downloaded file is left")
+	}
+}
+
+func TestJoi_saveConvertedFile(t *testing.T) {
+	db = NewDatabase(redisTestingDatabaseKeyPrefix, redisTestingConfig)
+	err := db.client.FlushDB(redisContext).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	photo := &PostInfo{Id: "photo", Time: TimeIsNotSpecified,
+		Files: []TgFileInfo{{Type: TelegramFileTypePhoto, Id: "photo"}}, AdminPostedId: 1000, OriginalMsgIds: []int64{1}}
+	document := &PostInfo{Id: "document", Time: TimeIsNotSpecified,
+		Files: []TgFileInfo{{Type: TelegramFileTypeDocPhoto, Id: "document"}}, AdminPostedId: 1000, OriginalMsgIds: []int64{2}}
+	for _, post := range []*PostInfo{photo, document} {
+		_, err = db.AddPost(post)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	joi := &Joi{Database: db}
+
+	// the file is converted while its post is merged into another one
+	_, err = db.MergePosts(photo.Id, document.Id, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	converted := document.Files[0]
+	converted.ConvertedId = "converted document"
+	joi.saveConvertedFile(document.AdminPostedId, document.OriginalMsgIds[0], converted)
+	post, err := db.GetPost(photo.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if post.Files[1].ConvertedId != converted.ConvertedId {
+		t.Errorf("converted file isn't saved to the post it's merged into: %+v", post.Files)
+	}
+
+	err = db.RemovePost(photo.Id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	joi.saveConvertedFile(document.AdminPostedId, document.OriginalMsgIds[0], converted)
+	if contains, _ := db.ContainsPost(photo.Id); contains {
+		t.Errorf("removed post is brought back by the converted file")
+	}
+}
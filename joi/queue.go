@@ -0,0 +1,288 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const queuePageSize = 10
+const queueLineLength = 60
+
+// TelegramMaximumCallbackData is the limit of the callback data in bytes, telebot adds the unique of the button to it
+const TelegramMaximumCallbackData = 64
+
+var (
+	queuePageButton = &tele.Btn{Unique: "queue_page"} // data: page|time|tag|admin id|text
+	queueOpenButton = &tele.Btn{Unique: "queue_open"} // data: post id
+)
+
+// queueFilter selects posts of the queue, empty fields match any post
+type queueFilter struct {
+	Time    string
+	Tag     string
+	AdminId int64
+	Text    string // a part of the text or the comment, case insensitive
+}
+
+// parseQueueFilter reads /list arguments: a slot (06:06 or NA), #tag and id of the admin, who added the post
+func parseQueueFilter(args []string) (queueFilter, error) {
+	filter := queueFilter{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "#"):
+			tags, err := parseTags([]string{arg})
+			if err != nil {
+				return filter, err
+			}
+			filter.Tag = tags[0]
+		case isTimeValid(arg):
+			filter.Time = strings.ToUpper(strings.TrimSpace(arg))
+		default:
+			adminId, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return filter, errors.New(fmt.Sprintf("'%s' is neither a slot, a #tag nor an admin id", arg))
+			}
+			filter.AdminId = adminId
+		}
+	}
+	return filter, nil
+}
+
+func (filter queueFilter) matches(post *PostInfo) bool {
+	if filter.Time != "" && post.Time != filter.Time {
+		return false
+	}
+	if filter.Tag != "" && !contains(post.Tags, filter.Tag) {
+		return false
+	}
+	if filter.AdminId != 0 && post.AdminPostedId != filter.AdminId {
+		return false
+	}
+	if filter.Text != "" {
+		text := strings.ToLower(filter.Text)
+		return strings.Contains(strings.ToLower(post.Text), text) || strings.Contains(strings.ToLower(post.Comment), text)
+	}
+	return true
+}
+
+func (filter queueFilter) String() string {
+	parts := make([]string, 0)
+	if filter.Time != "" {
+		parts = append(parts, "slot "+filter.Time)
+	}
+	if filter.Tag != "" {
+		parts = append(parts, "#"+filter.Tag)
+	}
+	if filter.AdminId != 0 {
+		parts = append(parts, fmt.Sprintf("admin %d", filter.AdminId))
+	}
+	if filter.Text != "" {
+		parts = append(parts, fmt.Sprintf("\"%s\"", filter.Text))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// callbackData encodes the page of the filtered queue for queuePageButton
+func (filter queueFilter) callbackData(page int) string {
+	adminId := ""
+	if filter.AdminId != 0 {
+		adminId = strconv.FormatInt(filter.AdminId, 10)
+	}
+	return strings.Join([]string{strconv.Itoa(page), filter.Time, filter.Tag, adminId, filter.Text}, "|")
+}
+
+func parseQueueCallbackData(data string) (queueFilter, int, error) {
+	fields := strings.SplitN(data, "|", 5)
+	if len(fields) < 5 {
+		return queueFilter{}, 0, errors.New(fmt.Sprintf("'%s' page of the queue is invalid formatted", data))
+	}
+	page, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return queueFilter{}, 0, err
+	}
+	filter := queueFilter{Time: fields[1], Tag: fields[2], Text: fields[4]}
+	if fields[3] != "" {
+		filter.AdminId, err = strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return queueFilter{}, 0, err
+		}
+	}
+	return filter, page, nil
+}
+
+// filterQueue returns the matching posts ordered by the slot, the free ones go last
+func filterQueue(posts []*PostInfo, filter queueFilter) []*PostInfo {
+	filtered := make([]*PostInfo, 0)
+	for _, post := range posts {
+		if filter.matches(post) {
+			filtered = append(filtered, post)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if a.Time != b.Time {
+			if a.Time == TimeIsNotSpecified || b.Time == TimeIsNotSpecified {
+				return b.Time == TimeIsNotSpecified
+			}
+			return a.Time < b.Time
+		}
+		return a.Id < b.Id
+	})
+	return filtered
+}
+
+// queuePage renders the page (from 0) of the posts as html, every post has a button, which opens it
+// in reply to the original admin message
+func queuePage(posts []*PostInfo, filter queueFilter, page int) (string, *tele.ReplyMarkup) {
+	pages := (len(posts) + queuePageSize - 1) / queuePageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	header := fmt.Sprintf("%d posts in the queue", len(posts))
+	if filter != (queueFilter{}) {
+		header = fmt.Sprintf("%d posts of %s", len(posts), html.EscapeString(filter.String()))
+	}
+	if pages > 1 {
+		header += fmt.Sprintf(", page %d/%d", page+1, pages)
+	}
+	lines := []string{header}
+
+	menu := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0)
+	buttons := make([]tele.Btn, 0, queuePageSize)
+	from := page * queuePageSize
+	for i := from; i < len(posts) && i < from+queuePageSize; i++ {
+		lines = append(lines, queueLine(i+1, posts[i]))
+		buttons = append(buttons, menu.Data(strconv.Itoa(i+1), queueOpenButton.Unique, posts[i].Id))
+	}
+	for len(buttons) > 0 {
+		size := len(buttons)
+		if size > queuePageSize/2 {
+			size = queuePageSize / 2
+		}
+		rows = append(rows, menu.Row(buttons[:size]...))
+		buttons = buttons[size:]
+	}
+
+	navigation := make([]tele.Btn, 0, 2)
+	if page > 0 {
+		navigation = append(navigation, menu.Data("◀️", queuePageButton.Unique, filter.callbackData(page-1)))
+	}
+	if page+1 < pages {
+		navigation = append(navigation, menu.Data("▶️", queuePageButton.Unique, filter.callbackData(page+1)))
+	}
+	if len(navigation) > 0 {
+		if len(queuePageButton.Unique)+len(filter.callbackData(pages))+2 > TelegramMaximumCallbackData {
+			lines = append(lines, "the search text is too long to turn pages, make it shorter to see the rest")
+		} else {
+			rows = append(rows, menu.Row(navigation...))
+		}
+	}
+	menu.Inline(rows...)
+	return strings.Join(lines, "\n\n"), menu
+}
+
+// queueLine describes the post by its number, id, slot, items and flags, followed by the first line of the text.
+// Posts added from a chat (i.e. the hot folder storage) link to the original message right away.
+func queueLine(number int, post *PostInfo) string {
+	id := fmt.Sprintf("<code>%s</code>", html.EscapeString(post.Id))
+	if post.AdminPostedId < 0 && len(post.OriginalMsgIds) > 0 {
+		id = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(messageLink(&tele.Chat{ID: post.AdminPostedId}, int(post.OriginalMsgIds[0]))), id)
+	}
+	flags := ""
+	if post.IsProtected {
+		flags += "🔒"
+	}
+	if post.PostSources == PostSourcesTrue {
+		flags += "📎"
+	}
+	if post.Poll != nil {
+		flags += "📊"
+	}
+	if post.Held {
+		flags += "⏸"
+	}
+
+	description := fmt.Sprintf("%d. %s · %s · %d items", number, id, post.Time, len(post.Files))
+	if flags != "" {
+		description += " · " + flags
+	}
+	if len(post.Tags) > 0 {
+		description += " · " + html.EscapeString(formatTags(post.Tags))
+	}
+
+	text := post.Text
+	if text == "" {
+		text = post.Comment
+	}
+	firstLine := strings.TrimSpace(strings.SplitN(text, "\n", 2)[0])
+	if firstLine == "" {
+		return description + "\n<i>no text</i>"
+	}
+	return description + "\n" + html.EscapeString(excerpt(firstLine, queueLineLength))
+}
+
+// handleQueue registers /list, /find and buttons of their pages in the admin group
+func (joi *Joi) handleQueue(admin *tele.Group) {
+	admin.Handle("/list", func(ctx tele.Context) error {
+		filter, err := parseQueueFilter(ctx.Args())
+		if err != nil {
+			return ctx.Reply(err.Error() + "\nusage: /list [06:06 or NA] [#tag] [admin id]")
+		}
+		return joi.sendQueuePage(ctx, filter, 0)
+	})
+	admin.Handle("/find", func(ctx tele.Context) error {
+		text := strings.TrimSpace(ctx.Message().Payload)
+		if text == "" {
+			return ctx.Reply("usage: /find <a part of the text or the comment>")
+		}
+		return joi.sendQueuePage(ctx, queueFilter{Text: text}, 0)
+	})
+	admin.Handle(queuePageButton, func(ctx tele.Context) error {
+		filter, page, err := parseQueueCallbackData(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		_ = ctx.Respond()
+		return joi.sendQueuePage(ctx, filter, page)
+	})
+	admin.Handle(queueOpenButton, func(ctx tele.Context) error {
+		post, err := joi.Database.GetPost(ctx.Callback().Data)
+		if err != nil {
+			return ctx.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		_ = ctx.Respond()
+		opts := &tele.SendOptions{ReplyMarkup: editorMarkup(post), AllowWithoutReply: true}
+		if post.AdminPostedId == ctx.Chat().ID && len(post.OriginalMsgIds) > 0 {
+			opts.ReplyTo = &tele.Message{ID: int(post.OriginalMsgIds[0]), Chat: ctx.Chat()}
+		}
+		return ctx.Send(editorSummary(post), opts)
+	})
+}
+
+// sendQueuePage sends the page of the filtered queue, pages turned by the buttons replace the previous ones
+func (joi *Joi) sendQueuePage(ctx tele.Context, filter queueFilter, page int) error {
+	posts, err := joi.Database.GetPosts()
+	if err != nil {
+		return err
+	}
+	text, menu := queuePage(filterQueue(posts, filter), filter, page)
+	opts := &tele.SendOptions{ParseMode: tele.ModeHTML, DisableWebPagePreview: true, ReplyMarkup: menu}
+	if ctx.Callback() != nil {
+		err = ctx.Edit(text, opts)
+		if isNotModified(err) {
+			return nil
+		}
+		return err
+	}
+	return ctx.Send(text, opts)
+}
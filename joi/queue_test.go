@@ -0,0 +1,69 @@
+package joi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseQueueFilter(t *testing.T) {
+	filter, err := parseQueueFilter([]string{"06:06", "#Art", "1000"})
+	if err != nil || filter != (queueFilter{Time: "06:06", Tag: "art", AdminId: 1000}) {
+		t.Errorf("filter is parsed into %+v, %v", filter, err)
+	}
+	filter, err = parseQueueFilter([]string{"na"})
+	if err != nil || filter.Time != TimeIsNotSpecified {
+		t.Errorf("free slot is parsed into %+v, %v", filter, err)
+	}
+	_, err = parseQueueFilter([]string{"tomorrow"})
+	if err == nil {
+		t.Errorf("invalid filter is parsed")
+	}
+
+	filter = queueFilter{Tag: "art", AdminId: 1000, Text: "a|b"}
+	parsed, page, err := parseQueueCallbackData(filter.callbackData(3))
+	if err != nil || parsed != filter || page != 3 {
+		t.Errorf("callback data is parsed into %+v, page %d, %v", parsed, page, err)
+	}
+}
+
+func TestQueuePage(t *testing.T) {
+	posts := make([]*PostInfo, 0)
+	for i := 0; i < 12; i++ {
+		posts = append(posts, &PostInfo{
+			Id:             fmt.Sprintf("post%02d", i),
+			Time:           []string{TimeIsNotSpecified, "21:21", "06:06"}[i%3],
+			Text:           fmt.Sprintf("text <%d>\nsecond line", i),
+			Files:          []TgFileInfo{{Type: TelegramFileTypePhoto}},
+			AdminPostedId:  1000,
+			OriginalMsgIds: []int64{int64(i)},
+		})
+	}
+	posts[4].Comment, posts[4].Held, posts[4].Tags = "kitty in the comment", true, []string{"art"}
+	posts[5].AdminPostedId = -1001234
+
+	filtered := filterQueue(posts, queueFilter{})
+	if filtered[0].Time != "06:06" || filtered[0].Id != "post02" || filtered[len(filtered)-1].Time != TimeIsNotSpecified {
+		t.Errorf("queue is ordered as %s %s ... %s", filtered[0].Time, filtered[0].Id, filtered[len(filtered)-1].Time)
+	}
+	if found := filterQueue(posts, queueFilter{Text: "KITTY"}); len(found) != 1 || found[0].Id != "post04" {
+		t.Errorf("%d posts are found", len(found))
+	}
+	if tagged := filterQueue(posts, queueFilter{Tag: "art", Time: "21:21"}); len(tagged) != 1 {
+		t.Errorf("%d posts are tagged", len(tagged))
+	}
+
+	text, menu := queuePage(filtered, queueFilter{}, 0)
+	if !strings.HasPrefix(text, "12 posts in the queue, page 1/2") || !strings.Contains(text, "1. <code>post02</code> · 06:06 · 1 items\ntext &lt;2&gt;") ||
+		!strings.Contains(text, "<a href=\"https://t.me/c/1234/5\"><code>post05</code></a>") || !strings.Contains(text, "⏸ · #art") {
+		t.Errorf("the first page is %s", text)
+	}
+	if len(menu.InlineKeyboard) != 3 || len(menu.InlineKeyboard[2]) != 1 || menu.InlineKeyboard[2][0].Text != "▶️" {
+		t.Errorf("the first page has %d rows of buttons", len(menu.InlineKeyboard))
+	}
+
+	text, menu = queuePage(filtered, queueFilter{}, 5)
+	if !strings.Contains(text, "page 2/2") || !strings.Contains(text, "11. ") || len(menu.InlineKeyboard) != 2 || menu.InlineKeyboard[1][0].Text != "◀️" {
+		t.Errorf("the last page is %s", text)
+	}
+}
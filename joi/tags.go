@@ -0,0 +1,89 @@
+package joi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var hashtagRegexp = regexp.MustCompile(`#[\p{L}\p{N}_]+`)
+var tagRegexp = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+
+// normalizeTag turns #Art into art, tags are stored lowercase without #
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// tagsOfText returns hashtags of the texts, i.e. of the caption
+func tagsOfText(texts ...string) []string {
+	tags := make([]string, 0)
+	for _, text := range texts {
+		for _, hashtag := range hashtagRegexp.FindAllString(text, -1) {
+			tags = append(tags, normalizeTag(hashtag))
+		}
+	}
+	return mergeTags(tags)
+}
+
+// parseTags reads tags given by the admin, with # or without it
+func parseTags(words []string) ([]string, error) {
+	tags := make([]string, 0, len(words))
+	for _, word := range words {
+		tag := normalizeTag(word)
+		if !tagRegexp.MatchString(tag) {
+			return nil, errors.New(fmt.Sprintf("'%s' isn't a valid tag, only letters, digits and _ are allowed", word))
+		}
+		tags = append(tags, tag)
+	}
+	return mergeTags(tags), nil
+}
+
+// mergeTags joins the lists, keeping the first occurrence of every tag
+func mergeTags(lists ...[]string) []string {
+	merged := make([]string, 0)
+	seen := map[string]bool{}
+	for _, tags := range lists {
+		for _, tag := range tags {
+			if !seen[tag] {
+				seen[tag] = true
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+func formatTags(tags []string) string {
+	hashtags := make([]string, len(tags))
+	for i, tag := range tags {
+		hashtags[i] = "#" + tag
+	}
+	return strings.Join(hashtags, " ")
+}
+
+// parseScheduleEntry splits the entry of the schedule into the time and the tags, posts of which the slot prefers,
+// i.e. 21:21#art#anime
+func parseScheduleEntry(entry string) (postTime string, tags []string) {
+	parts := strings.Split(entry, "#")
+	for _, tag := range parts[1:] {
+		if tag != "" {
+			tags = append(tags, normalizeTag(tag))
+		}
+	}
+	return parts[0], mergeTags(tags)
+}
+
+// isScheduleEntryValid tells whether the entry is a valid time with valid tags, if any
+func isScheduleEntryValid(entry string) bool {
+	postTime, tags := parseScheduleEntry(entry)
+	if !isTimeValid(postTime) {
+		return false
+	}
+	for _, tag := range tags {
+		if !tagRegexp.MatchString(tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package joi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagsOfText(t *testing.T) {
+	tags := tagsOfText("sunrise #Morning #art, #арт", "sources #art #2026_10")
+	if strings.Join(tags, " ") != "morning art арт 2026_10" {
+		t.Errorf("tags are %v", tags)
+	}
+
+	tags, err := parseTags([]string{"#Art", "anime", "art"})
+	if err != nil || strings.Join(tags, " ") != "art anime" {
+		t.Errorf("tags are parsed into %v, %v", tags, err)
+	}
+	_, err = parseTags([]string{"not-a-tag"})
+	if err == nil {
+		t.Errorf("invalid tag is parsed")
+	}
+}
+
+func TestParseScheduleEntry(t *testing.T) {
+	for _, test := range []struct {
+		entry string
+		time  string
+		tags  string
+		valid bool
+	}{
+		{"06:06", "06:06", "", true},
+		{"06:06#Morning", "06:06", "morning", true},
+		{"21:21#art#anime#art", "21:21", "art anime", true},
+		{"25:00#art", "25:00", "art", false},
+		{"21:21#a-b", "21:21", "a-b", false},
+	} {
+		postTime, tags := parseScheduleEntry(test.entry)
+		if postTime != test.time || strings.Join(tags, " ") != test.tags || isScheduleEntryValid(test.entry) != test.valid {
+			t.Errorf("'%s' is parsed into %s %v, valid: %t", test.entry, postTime, tags, isScheduleEntryValid(test.entry))
+		}
+	}
+}
@@ -5,17 +5,32 @@ import (
 	"errors"
 	"fmt"
 	tele "gopkg.in/telebot.v3"
+	"sort"
+	"sync"
 	"time"
 )
 
 const DefaultTimeout = time.Second * 1
 
+// MediaGroupsHandler collects messages of an album and handles them all at once,
+// the album is considered complete, when no new messages of it come for the Timeout
 type MediaGroupsHandler struct {
 	Timeout time.Duration
 	Handler func(messages []*tele.Message) error
 	Bot     *tele.Bot
 
-	groups map[string][]*tele.Message
+	mutex     sync.Mutex
+	groups    map[string]*mediaGroup
+	afterFunc func(time.Duration, func()) debounceTimer // time.AfterFunc, replaced in tests
+}
+
+type debounceTimer interface {
+	Reset(time.Duration) bool
+}
+
+type mediaGroup struct {
+	messages []*tele.Message
+	timer    debounceTimer
 }
 
 func NewMediaGroupsHandler(bot *tele.Bot, handler func([]*tele.Message) error) *MediaGroupsHandler {
@@ -23,50 +38,69 @@ func NewMediaGroupsHandler(bot *tele.Bot, handler func([]*tele.Message) error) *
 		Timeout: DefaultTimeout,
 		Handler: handler,
 		Bot:     bot,
-		groups:  make(map[string][]*tele.Message),
+		groups:  make(map[string]*mediaGroup),
+		afterFunc: func(timeout time.Duration, f func()) debounceTimer {
+			return time.AfterFunc(timeout, f)
+		},
 	}
 }
 
 func (handler *MediaGroupsHandler) Register() func(tele.Context) error {
 	return func(ctx tele.Context) error {
 		message := deepCopyViaJsonSorryJesusChrist(ctx.Message())
-		switch {
-		case message.Voice != nil:
-			return errors.New("voice messages are not supported right now")
-		case message.Sticker != nil:
-			return errors.New("stickers are not supported right now")
-		case message.VideoNote != nil:
-			return errors.New("video notes are not supported right now")
+		id := mediaGroupToId(message)
+
+		defer handler.mutex.Unlock()
+		handler.mutex.Lock()
+
+		if group, contains := handler.groups[id]; contains {
+			group.messages = append(group.messages, message)
+			group.timer.Reset(handler.Timeout)
+			return nil
 		}
 
-		id := mediaGroupToId(message)
-		if _, contains := handler.groups[id]; !contains {
-			handler.groups[id] = []*tele.Message{message}
-
-			go func() {
-				defer func() {
-					delete(handler.groups, id)
-					if r := recover(); r != nil {
-						handler.Bot.OnError(errors.New(fmt.Sprintf("%v", r)),
-							handler.Bot.NewContext(tele.Update{Message: deepCopyViaJsonSorryJesusChrist(message)}))
-					}
-				}()
-				if message.AlbumID != "" {
-					time.Sleep(handler.Timeout)
-				}
-				err := handler.Handler(handler.groups[mediaGroupToId(message)])
-				if err != nil {
-					handler.Bot.OnError(err, handler.Bot.NewContext(tele.Update{Message: deepCopyViaJsonSorryJesusChrist(message)}))
-				}
-			}()
-		} else {
-			handler.groups[id] = append(handler.groups[id], message)
+		group := &mediaGroup{messages: []*tele.Message{message}}
+		handler.groups[id] = group
+		timeout := handler.Timeout
+		if message.AlbumID == "" {
+			timeout = 0 // a single message is complete right away
 		}
+		group.timer = handler.afterFunc(timeout, func() {
+			handler.flush(id, group)
+		})
 
 		return nil
 	}
 }
 
+// flush handles the group, unless it has been already handled
+func (handler *MediaGroupsHandler) flush(id string, group *mediaGroup) {
+	handler.mutex.Lock()
+	if handler.groups[id] != group {
+		handler.mutex.Unlock()
+		return // the timer was reset after it had fired
+	}
+	delete(handler.groups, id)
+	messages := group.messages
+	handler.mutex.Unlock()
+
+	// updates might come in any order
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	defer func() {
+		if r := recover(); r != nil {
+			handler.Bot.OnError(errors.New(fmt.Sprintf("%v", r)),
+				handler.Bot.NewContext(tele.Update{Message: deepCopyViaJsonSorryJesusChrist(messages[0])}))
+		}
+	}()
+	err := handler.Handler(messages)
+	if err != nil {
+		handler.Bot.OnError(err, handler.Bot.NewContext(tele.Update{Message: deepCopyViaJsonSorryJesusChrist(messages[0])}))
+	}
+}
+
 func deepCopyViaJsonSorryJesusChrist[T any](obj *T) *T {
 	buff, err := json.Marshal(obj)
 	if err != nil {
@@ -0,0 +1,167 @@
+package joi
+
+import (
+	"fmt"
+	tele "gopkg.in/telebot.v3"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimers replaces time.AfterFunc of the handler, timers fire only when the test says so
+type testTimers struct {
+	mutex  sync.Mutex
+	timers []*testTimer
+}
+
+type testTimer struct {
+	timers  *testTimers
+	f       func()
+	pending bool
+}
+
+func (timers *testTimers) afterFunc(_ time.Duration, f func()) debounceTimer {
+	defer timers.mutex.Unlock()
+	timers.mutex.Lock()
+	timer := &testTimer{timers: timers, f: f, pending: true}
+	timers.timers = append(timers.timers, timer)
+	return timer
+}
+
+func (timer *testTimer) Reset(time.Duration) bool {
+	defer timer.timers.mutex.Unlock()
+	timer.timers.mutex.Lock()
+	wasPending := timer.pending
+	timer.pending = true
+	return wasPending
+}
+
+// fire runs all the pending timers, as if their timeout has passed
+func (timers *testTimers) fire() {
+	timers.mutex.Lock()
+	pending := make([]*testTimer, 0)
+	for _, timer := range timers.timers {
+		if timer.pending {
+			timer.pending = false
+			pending = append(pending, timer)
+		}
+	}
+	timers.mutex.Unlock()
+
+	for _, timer := range pending {
+		timer.f()
+	}
+}
+
+func newTestMediaGroupsHandler(t *testing.T) (*MediaGroupsHandler, func() [][]int, *testTimers) {
+	bot, err := tele.NewBot(tele.Settings{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutex := sync.Mutex{}
+	handled := make([][]int, 0)
+	handler := NewMediaGroupsHandler(bot, func(messages []*tele.Message) error {
+		ids := make([]int, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		mutex.Lock()
+		handled = append(handled, ids)
+		mutex.Unlock()
+		return nil
+	})
+	timers := &testTimers{}
+	handler.afterFunc = timers.afterFunc
+
+	return handler, func() [][]int {
+		defer mutex.Unlock()
+		mutex.Lock()
+		return handled
+	}, timers
+}
+
+func testAlbumMessage(bot *tele.Bot, albumId string, id int) tele.Context {
+	return bot.NewContext(tele.Update{Message: &tele.Message{
+		ID:      id,
+		AlbumID: albumId,
+		Chat:    &tele.Chat{ID: 1},
+		Sender:  &tele.User{ID: 1},
+		Photo:   &tele.Photo{File: tele.File{FileID: fmt.Sprintf("photo %d", id)}},
+	}})
+}
+
+func TestMediaGroupsHandler_Interleaved(t *testing.T) {
+	handler, handled, timers := newTestMediaGroupsHandler(t)
+	register := handler.Register()
+
+	// two albums and a single photo, which updates come concurrently and out of order
+	updates := []tele.Context{
+		testAlbumMessage(handler.Bot, "first", 3), testAlbumMessage(handler.Bot, "second", 12),
+		testAlbumMessage(handler.Bot, "first", 1), testAlbumMessage(handler.Bot, "", 20),
+		testAlbumMessage(handler.Bot, "second", 11), testAlbumMessage(handler.Bot, "first", 2),
+		testAlbumMessage(handler.Bot, "second", 10), testAlbumMessage(handler.Bot, "first", 4),
+	}
+	wg := sync.WaitGroup{}
+	for _, update := range updates {
+		wg.Add(1)
+		go func(update tele.Context) {
+			defer wg.Done()
+			err := register(update)
+			if err != nil {
+				t.Error(err)
+			}
+		}(update)
+	}
+	wg.Wait()
+	if len(handled()) != 0 {
+		t.Fatalf("groups are handled before the timeout: %v", handled())
+	}
+
+	timers.fire()
+	groups := map[string]bool{}
+	for _, group := range handled() {
+		groups[fmt.Sprint(group)] = true
+	}
+	if len(groups) != 3 || !groups["[1 2 3 4]"] || !groups["[10 11 12]"] || !groups["[20]"] {
+		t.Errorf("handled groups are %v, expected [1 2 3 4], [10 11 12] and [20]", handled())
+	}
+}
+
+func TestMediaGroupsHandler_Debounce(t *testing.T) {
+	handler, handled, timers := newTestMediaGroupsHandler(t)
+	register := handler.Register()
+
+	// every next message of the album comes in time, so the timer is reset instead of firing
+	for id := 1; id <= 5; id++ {
+		err := register(testAlbumMessage(handler.Bot, "slow", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(handled()) != 0 {
+			t.Fatalf("album is handled before it is complete: %v", handled())
+		}
+	}
+	if len(timers.timers) != 1 {
+		t.Fatalf("%d timers are started for the album, expected 1", len(timers.timers))
+	}
+
+	timers.fire()
+	if fmt.Sprint(handled()) != "[[1 2 3 4 5]]" {
+		t.Errorf("handled groups are %v, expected [[1 2 3 4 5]]", handled())
+	}
+
+	// the same album again is a new group, the fired timer doesn't handle it twice
+	err := register(testAlbumMessage(handler.Bot, "slow", 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timers.timers[0].f()
+	if len(handled()) != 1 {
+		t.Errorf("the fired timer has handled the new group: %v", handled())
+	}
+	timers.fire()
+	if fmt.Sprint(handled()) != "[[1 2 3 4 5] [6]]" {
+		t.Errorf("handled groups are %v, expected [[1 2 3 4 5] [6]]", handled())
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"joi2/joi"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -40,5 +42,12 @@ func main() {
 		log.Fatalln(err.Error())
 	}
 
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		bot.Stop()
+	}()
+
 	bot.Start()
 }